@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Suffix of the rules file of a profile, e.g. ~/.logstasher/default.alerts.json
+const alertRulesSuffix = ".alerts.json"
+
+// How long command and url hooks of an alert may run
+const alertHookTimeout = 5 * time.Second
+
+// Threshold alert rule read from the rules file. Rule fires when more than Threshold entries of the tailed stream
+// pass all the conditions (source, contains and filters) within the sliding window ending now, and resolves when the
+// count drops to the threshold again.
+type AlertRule struct {
+	Name      string   `json:"name"`
+	Source    string   `json:"source"`
+	Contains  string   `json:"contains"`
+	Filters   []string `json:"filters"`
+	Threshold int      `json:"threshold"`
+	Window    string   `json:"window"`
+	// Hooks invoked when the rule fires or resolves, with the alert as JSON
+	Command string `json:"command"`
+	Url     string `json:"url"`
+}
+
+// Alert passed to the hooks
+type alertEvent struct {
+	Rule      string `json:"rule"`
+	State     string `json:"state"`
+	Count     int    `json:"count"`
+	Threshold int    `json:"threshold"`
+	Window    string `json:"window"`
+	Time      string `json:"time"`
+}
+
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+type alertState struct {
+	rule       *AlertRule
+	filters    []Filter
+	window     time.Duration
+	timestamps []time.Time
+	firing     bool
+}
+
+//
+// Evaluates alert rules over sliding windows of the entries processed while tailing
+//
+type alertEvaluator struct {
+	states []*alertState
+}
+
+// Returns path of the rules file of the profile
+func alertRulesPath(profile string) string {
+	return userHomeDir() + string(os.PathSeparator) + confDir + string(os.PathSeparator) + profile + alertRulesSuffix
+}
+
+// Loads alert rules from the file. If the path is empty, rules file of the profile is used if it exists. Returns nil
+// if there are no rules.
+func loadAlertRules(path string, profile string) (*alertEvaluator, error) {
+	if path == "" {
+		path = alertRulesPath(profile)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read alert rules from %s: %s", path, err)
+	}
+	var rules []*AlertRule
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return nil, fmt.Errorf("Failed to parse alert rules in %s: %s", path, err)
+	}
+	evaluator := &alertEvaluator{}
+	for i, rule := range rules {
+		state, err := newAlertState(rule)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid alert rule %d in %s: %s", i+1, path, err)
+		}
+		evaluator.states = append(evaluator.states, state)
+	}
+	if len(evaluator.states) == 0 {
+		return nil, nil
+	}
+	Info.Printf("Loaded %d alert rules from %s", len(evaluator.states), path)
+	return evaluator, nil
+}
+
+func newAlertState(rule *AlertRule) (*alertState, error) {
+	if rule.Name == "" {
+		return nil, fmt.Errorf("name is missing")
+	}
+	if rule.Threshold < 0 {
+		return nil, fmt.Errorf("threshold must not be negative")
+	}
+	window, err := parseDuration(rule.Window)
+	if err != nil {
+		return nil, err
+	}
+	state := &alertState{rule: rule, window: window}
+	if rule.Source != "" {
+		state.filters = append(state.filters, TermsFilter{Field: "source", Values: strings.Split(rule.Source, ",")})
+	}
+	if rule.Contains != "" {
+		state.filters = append(state.filters, MatchPhraseFilter{Field: "message", Phrase: rule.Contains})
+	}
+	for _, expression := range rule.Filters {
+		filter, err := ParseFieldFilter(expression)
+		if err != nil {
+			return nil, err
+		}
+		state.filters = append(state.filters, filter)
+	}
+	return state, nil
+}
+
+// Counts the entry in windows of the rules it matches
+func (e *alertEvaluator) observe(timestamp time.Time, entry map[string]interface{}) {
+	for _, state := range e.states {
+		if state.matches(entry) {
+			state.timestamps = append(state.timestamps, timestamp)
+		}
+	}
+}
+
+// Slides windows of the rules to now and fires or resolves the rules whose count crossed the threshold
+func (e *alertEvaluator) evaluate(now time.Time) {
+	for _, state := range e.states {
+		count := state.slide(now)
+		if !state.firing && count > state.rule.Threshold {
+			state.firing = true
+			state.notify(alertFiring, count, now)
+		} else if state.firing && count <= state.rule.Threshold {
+			state.firing = false
+			state.notify(alertResolved, count, now)
+		}
+	}
+}
+
+func (s *alertState) matches(entry map[string]interface{}) bool {
+	for _, filter := range s.filters {
+		if !filterMatches(filter, entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// Drops entries older than the window and returns the number of entries within the window
+func (s *alertState) slide(now time.Time) int {
+	start := now.Add(-s.window)
+	kept := s.timestamps[:0]
+	for _, timestamp := range s.timestamps {
+		if timestamp.After(start) {
+			kept = append(kept, timestamp)
+		}
+	}
+	s.timestamps = kept
+	return len(kept)
+}
+
+// Prints banner and invokes hooks of the rule
+func (s *alertState) notify(state string, count int, now time.Time) {
+	event := &alertEvent{Rule: s.rule.Name, State: state, Count: count, Threshold: s.rule.Threshold,
+		Window: s.window.String(), Time: now.UTC().Format(time.RFC3339)}
+	banner := fmt.Sprintf("==== ALERT %s: %s - %d entries within %s (threshold %d) at %s ====", strings.ToUpper(state),
+		event.Rule, count, event.Window, event.Threshold, now.In(localTz).Format("2006-01-02 15:04:05"))
+	if state == alertFiring {
+		fmt.Fprintln(InfoOutput, color.New(color.FgWhite, color.BgRed, color.Bold).SprintFunc()(banner))
+	} else {
+		fmt.Fprintln(InfoOutput, color.New(color.FgBlack, color.BgGreen).SprintFunc()(banner))
+	}
+
+	payload, _ := json.Marshal(event)
+	//hooks run in the background, so that a slow hook does not hold up tailing and evaluation of the rules
+	go s.invokeHooks(payload)
+}
+
+// Invokes the command and url hooks of the rule, each is given alertHookTimeout to complete
+func (s *alertState) invokeHooks(payload []byte) {
+	if s.rule.Command != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), alertHookTimeout)
+		command := exec.CommandContext(ctx, "sh", "-c", s.rule.Command)
+		command.Stdin = bytes.NewReader(payload)
+		command.Stdout = os.Stderr
+		command.Stderr = os.Stderr
+		if err := command.Run(); err != nil {
+			Error.Printf("Alert command %s failed: %s\n", s.rule.Command, err)
+		}
+		cancel()
+	}
+	if s.rule.Url != "" {
+		client := &http.Client{Timeout: alertHookTimeout}
+		response, err := client.Post(s.rule.Url, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			Error.Printf("Alert hook %s failed: %s\n", s.rule.Url, err)
+			return
+		}
+		response.Body.Close()
+		if response.StatusCode >= 300 {
+			Error.Printf("Alert hook %s failed: %s\n", s.rule.Url, response.Status)
+		}
+	}
+}
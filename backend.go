@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+//
+// Backend that stores the logs. Tail builds backend independent search requests and the backend translates them to
+// the query DSL understood by the cluster it talks to.
+//
+type LogBackend interface {
+	// Human readable description of the backend, e.g. "Elasticsearch 7.10.2"
+	Name() string
+	// Names of all the indices available in the backend
+	IndexNames() ([]string, error)
+	// Executes the search request
+	Search(request *SearchRequest) (*SearchResponse, error)
+	// Fetches the page of entries following the cursor, or the first page if the cursor is empty. Entries are sorted by
+	// timestamp in ascending order and ties are broken by a backend specific tiebreaker, so that paging is stable.
+	TailPage(request *SearchRequest, timestampField string, cursor *tailCursor) (*SearchResponse, error)
+	// Fetches all entries matching the request in pages of request.Size sorted by request.Sort, handing each page to
+	// the callback until it returns false or there are no more entries
+	Scroll(request *SearchRequest, page func(*SearchResponse) bool) error
+	// Executes aggregations of the request without fetching any hits
+	Aggregate(request *SearchRequest) (AggregationResults, error)
+	// Describes the search request exactly as it would be sent to the backend, without executing it
+	DescribeRequest(request *SearchRequest) (string, error)
+	// Asks the backend to validate the query of the request and explain how it is interpreted
+	ValidateQuery(request *SearchRequest) (string, error)
+	// Fields defined in the mappings of the indices, keyed by dot-path of the field
+	FieldMappings(indices []string) (map[string]*FieldMapping, error)
+}
+
+// -- Mappings --
+
+// Field of the indices. Fields mapped differently in different indices have all the types joined by |.
+type FieldMapping struct {
+	Path string
+	Type string
+	// Text is split into terms when indexed, so it can't be matched exactly or aggregated
+	Analyzed bool
+	// Field is indexed from the value of its parent field (e.g. host.keyword) and is not present in the entries
+	MultiField bool
+}
+
+// -- Requests --
+
+// Backend independent search request
+type SearchRequest struct {
+	Indices      []string
+	Query        Query
+	Sort         []SortField
+	From         int
+	Size         int
+	// Sort values of the hit the page follows. Backends that support it (search_after) use it instead of From.
+	SearchAfter  []interface{}
+	Aggregations map[string]Aggregation
+	// Asks the backend to report which parts of the fields of the hits matched the query
+	Highlight bool
+}
+
+// Query matches entries containing keywords (query string syntax, searched in message field by default) that pass all
+// the filters. Empty query string matches all entries.
+type Query struct {
+	QueryString string
+	Filters     []Filter
+}
+
+type SortField struct {
+	Field     string
+	Ascending bool
+}
+
+// Filter is one of TermsFilter, RangeFilter, ExistsFilter, RegexpFilter, MatchPhraseFilter or NotFilter
+type Filter interface{}
+
+// Matches entries whose field is equal to one of the values
+type TermsFilter struct {
+	Field  string
+	Values []string
+}
+
+// Matches entries whose field is within the range. Nil bounds are not applied.
+type RangeFilter struct {
+	Field string
+	Gt    interface{}
+	Gte   interface{}
+	Lt    interface{}
+	Lte   interface{}
+}
+
+// Matches entries that have the field
+type ExistsFilter struct {
+	Field string
+}
+
+// Matches entries whose field matches the regular expression
+type RegexpFilter struct {
+	Field  string
+	Regexp string
+}
+
+// Matches entries whose analyzed field contains the phrase (or whose keyword field is equal to it)
+type MatchPhraseFilter struct {
+	Field  string
+	Phrase string
+}
+
+// Matches entries that do not pass the filter
+type NotFilter struct {
+	Filter Filter
+}
+
+// Aggregation is one of TermsAggregation, DateHistogramAggregation, HistogramAggregation, MinAggregation,
+// MaxAggregation, StatsAggregation or PercentilesAggregation
+type Aggregation interface{}
+
+// Buckets entries by values of the field, most frequent values first unless ordered by key
+type TermsAggregation struct {
+	Field           string
+	Size            int
+	OrderByKey      bool
+	SubAggregations map[string]Aggregation
+}
+
+// Buckets entries by time intervals of the field. Empty buckets between bounds are returned as well.
+type DateHistogramAggregation struct {
+	Field           string
+	Interval        time.Duration
+	Min             time.Time
+	Max             time.Time
+	SubAggregations map[string]Aggregation
+}
+
+type MinAggregation struct {
+	Field string
+}
+
+type MaxAggregation struct {
+	Field string
+}
+
+// Buckets numeric values of the field by fixed interval. Empty buckets between bounds are returned as well.
+type HistogramAggregation struct {
+	Field    string
+	Interval float64
+	Min      float64
+	Max      float64
+}
+
+// Count, min, max, avg and sum of numeric values of the field
+type StatsAggregation struct {
+	Field string
+}
+
+// Approximate percentiles of numeric values of the field
+type PercentilesAggregation struct {
+	Field    string
+	Percents []float64
+}
+
+// -- Responses --
+
+type SearchResponse struct {
+	TotalHits    int64
+	Hits         []*SearchHit
+	Aggregations AggregationResults
+}
+
+type SearchHit struct {
+	Index  string
+	Type   string
+	Id     string
+	// Sort values of the hit as reported by the backend, nil if the backend doesn't report them
+	Sort   []interface{}
+	Source json.RawMessage
+	// Parts of the fields that matched the query keyed by field, only if requested (see SearchRequest.Highlight)
+	Highlight map[string][]string
+}
+
+// Unique id of the hit across all indices
+func (h *SearchHit) Uid() string {
+	return h.Index + "/" + h.Type + "#" + h.Id
+}
+
+// Aggregation results keyed by aggregation name. Results are kept raw and parsed on access, as their structure
+// depends on the type of the aggregation.
+type AggregationResults map[string]json.RawMessage
+
+type AggregationBucket struct {
+	Key          interface{}
+	KeyAsString  string
+	DocCount     int64
+	Aggregations AggregationResults
+}
+
+// Returns the key of the bucket as string
+func (b *AggregationBucket) KeyString() string {
+	if b.KeyAsString != "" {
+		return b.KeyAsString
+	}
+	return fmt.Sprintf("%v", b.Key)
+}
+
+// Returns buckets of a bucket aggregation (e.g. terms aggregation)
+func (a AggregationResults) Buckets(name string) ([]*AggregationBucket, bool) {
+	raw, ok := a[name]
+	if !ok {
+		return nil, false
+	}
+	var result struct {
+		Buckets []map[string]json.RawMessage `json:"buckets"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		Trace.Printf("Failed parsing buckets of aggregation %s: %s", name, err)
+		return nil, false
+	}
+	buckets := make([]*AggregationBucket, 0, len(result.Buckets))
+	for _, rawBucket := range result.Buckets {
+		bucket := &AggregationBucket{Aggregations: make(AggregationResults)}
+		for key, value := range rawBucket {
+			switch key {
+			case "key":
+				json.Unmarshal(value, &bucket.Key)
+			case "key_as_string":
+				json.Unmarshal(value, &bucket.KeyAsString)
+			case "doc_count":
+				json.Unmarshal(value, &bucket.DocCount)
+			default:
+				bucket.Aggregations[key] = value
+			}
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, true
+}
+
+// Returns number of entries not included in the returned buckets of a terms aggregation
+func (a AggregationResults) OtherDocCount(name string) int64 {
+	var result struct {
+		SumOtherDocCount int64 `json:"sum_other_doc_count"`
+	}
+	if raw, ok := a[name]; ok {
+		json.Unmarshal(raw, &result)
+	}
+	return result.SumOtherDocCount
+}
+
+type Stats struct {
+	Count int64    `json:"count"`
+	Min   *float64 `json:"min"`
+	Max   *float64 `json:"max"`
+	Avg   *float64 `json:"avg"`
+	Sum   float64  `json:"sum"`
+}
+
+// Returns result of a stats aggregation
+func (a AggregationResults) Stats(name string) (*Stats, bool) {
+	raw, ok := a[name]
+	if !ok {
+		return nil, false
+	}
+	var stats Stats
+	if err := json.Unmarshal(raw, &stats); err != nil {
+		Trace.Printf("Failed parsing stats of aggregation %s: %s", name, err)
+		return nil, false
+	}
+	return &stats, true
+}
+
+// Returns result of a percentiles aggregation keyed by percent. Percentiles are nil if there were no values.
+func (a AggregationResults) Percentiles(name string) (map[float64]*float64, bool) {
+	raw, ok := a[name]
+	if !ok {
+		return nil, false
+	}
+	var result struct {
+		Values map[string]*float64 `json:"values"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		Trace.Printf("Failed parsing percentiles of aggregation %s: %s", name, err)
+		return nil, false
+	}
+	percentiles := make(map[float64]*float64, len(result.Values))
+	for key, value := range result.Values {
+		percent, err := strconv.ParseFloat(key, 64)
+		if err != nil {
+			continue
+		}
+		percentiles[percent] = value
+	}
+	return percentiles, true
+}
+
+// Returns value of a single value metric aggregation (e.g. min or max aggregation). Value is nil if there were no
+// entries to aggregate.
+func (a AggregationResults) Value(name string) (*float64, bool) {
+	raw, ok := a[name]
+	if !ok {
+		return nil, false
+	}
+	var result struct {
+		Value *float64 `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		Trace.Printf("Failed parsing value of aggregation %s: %s", name, err)
+		return nil, false
+	}
+	return result.Value, true
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// Query DSL dialect of a generation of Elasticsearch/OpenSearch clusters.
+//
+type queryDialect interface {
+	// Translates the query to DSL
+	query(query Query) map[string]interface{}
+	// Translates the aggregation to DSL
+	aggregation(aggregation Aggregation) map[string]interface{}
+	// Unique field used to break ties between entries sharing the same timestamp
+	tiebreakerField() string
+	// True if pages can follow sort values of the last hit of the previous page (search_after was added in 5.x)
+	supportsSearchAfter() bool
+	// Wraps full text query so that it can be used as a filter
+	queryAsFilter(query map[string]interface{}) interface{}
+}
+
+//
+// Backend for Elasticsearch and OpenSearch clusters, query DSL is produced by the dialect matching the cluster version.
+//
+type esBackend struct {
+	client  *esClient
+	dialect queryDialect
+	name    string
+}
+
+// Connects to the cluster and selects dialect based on the version reported by the cluster. Clusters older than 5.x
+// are queried using legacy DSL (filtered queries), newer ones and OpenSearch using the bool query DSL.
+func NewElasticsearchBackend(url string, user string, password string, traceRequests bool) (LogBackend, error) {
+	client := newESClient(url, user, password, traceRequests)
+	info, err := client.clusterInfo()
+	if err != nil {
+		return nil, err
+	}
+	distribution := "Elasticsearch"
+	if info.Version.Distribution == "opensearch" {
+		distribution = "OpenSearch"
+	}
+	major := majorVersion(info.Version.Number)
+	backend := &esBackend{client: client, name: distribution + " " + info.Version.Number}
+	if distribution == "Elasticsearch" && major < 5 {
+		backend.dialect = new(legacyDialect)
+	} else {
+		backend.dialect = &modernDialect{major: major, opensearch: distribution == "OpenSearch"}
+	}
+	Info.Printf("Connected to %s", backend.name)
+	return backend, nil
+}
+
+func majorVersion(version string) int {
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil {
+		Info.Printf("Could not parse cluster version %s, assuming modern cluster", version)
+		return 7
+	}
+	return major
+}
+
+func (b *esBackend) Name() string {
+	return b.name
+}
+
+func (b *esBackend) IndexNames() ([]string, error) {
+	var aliases map[string]interface{}
+	if err := b.client.perform("GET", "/_aliases", nil, nil, &aliases); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Mappings contain one mapping per type before 7.x and a single typeless mapping since then
+func (b *esBackend) FieldMappings(indices []string) (map[string]*FieldMapping, error) {
+	var mappings map[string]struct {
+		Mappings map[string]json.RawMessage `json:"mappings"`
+	}
+	if err := b.client.perform("GET", mappingPath(indices), nil, nil, &mappings); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]*FieldMapping)
+	for _, index := range mappings {
+		if properties, ok := index.Mappings["properties"]; ok {
+			collectFieldMappings(fields, "", properties, false)
+			continue
+		}
+		for typeName, mapping := range index.Mappings {
+			if typeName == "_default_" {
+				continue
+			}
+			var typeMapping struct {
+				Properties json.RawMessage `json:"properties"`
+			}
+			if err := json.Unmarshal(mapping, &typeMapping); err != nil {
+				return nil, err
+			}
+			collectFieldMappings(fields, "", typeMapping.Properties, false)
+		}
+	}
+	return fields, nil
+}
+
+type esFieldMapping struct {
+	Type       string                     `json:"type"`
+	Index      interface{}                `json:"index"`
+	Properties json.RawMessage            `json:"properties"`
+	Fields     map[string]json.RawMessage `json:"fields"`
+}
+
+// Flattens properties of the mapping into fields keyed by dot-path. Objects are not fields themselves, only their
+// properties are collected.
+func collectFieldMappings(fields map[string]*FieldMapping, prefix string, properties json.RawMessage, multiField bool) {
+	var mappings map[string]esFieldMapping
+	if len(properties) == 0 || json.Unmarshal(properties, &mappings) != nil {
+		return
+	}
+	for name, mapping := range mappings {
+		path := prefix + name
+		if len(mapping.Properties) > 0 {
+			collectFieldMappings(fields, path+".", mapping.Properties, multiField)
+			continue
+		}
+		addFieldMapping(fields, &FieldMapping{
+			Path:       path,
+			Type:       mapping.Type,
+			Analyzed:   mapping.Type == "text" || (mapping.Type == "string" && mapping.Index != "not_analyzed" && mapping.Index != "no"),
+			MultiField: multiField,
+		})
+		if len(mapping.Fields) > 0 {
+			multiFields, _ := json.Marshal(mapping.Fields)
+			collectFieldMappings(fields, path+".", multiFields, true)
+		}
+	}
+}
+
+func addFieldMapping(fields map[string]*FieldMapping, field *FieldMapping) {
+	existing, ok := fields[field.Path]
+	if !ok {
+		fields[field.Path] = field
+		return
+	}
+	existing.Analyzed = existing.Analyzed || field.Analyzed
+	for _, t := range strings.Split(existing.Type, "|") {
+		if t == field.Type {
+			return
+		}
+	}
+	existing.Type += "|" + field.Type
+}
+
+// Search response as returned by the cluster
+type esSearchResponse struct {
+	ScrollId string `json:"_scroll_id"`
+	Hits     struct {
+		Total json.RawMessage `json:"total"`
+		Hits  []struct {
+			Index     string              `json:"_index"`
+			Type      string              `json:"_type"`
+			Id        string              `json:"_id"`
+			Sort      []json.RawMessage   `json:"sort"`
+			Source    json.RawMessage     `json:"_source"`
+			Highlight map[string][]string `json:"highlight"`
+		} `json:"hits"`
+	} `json:"hits"`
+	Aggregations AggregationResults `json:"aggregations"`
+}
+
+func (b *esBackend) Search(request *SearchRequest) (*SearchResponse, error) {
+	var raw esSearchResponse
+	if err := b.client.perform("POST", searchPath(request.Indices), nil, b.searchBody(request), &raw); err != nil {
+		return nil, err
+	}
+	return raw.searchResponse(), nil
+}
+
+func (raw *esSearchResponse) searchResponse() *SearchResponse {
+	response := &SearchResponse{
+		TotalHits:    parseTotalHits(raw.Hits.Total),
+		Hits:         make([]*SearchHit, 0, len(raw.Hits.Hits)),
+		Aggregations: raw.Aggregations,
+	}
+	for _, hit := range raw.Hits.Hits {
+		//sort values are kept raw, so that long values (e.g. dates) are passed back to search_after without rounding
+		var sortValues []interface{}
+		for _, value := range hit.Sort {
+			sortValues = append(sortValues, value)
+		}
+		response.Hits = append(response.Hits, &SearchHit{
+			Index:     hit.Index,
+			Type:      hit.Type,
+			Id:        hit.Id,
+			Sort:      sortValues,
+			Source:    hit.Source,
+			Highlight: highlightedParts(hit.Highlight),
+		})
+	}
+	return response
+}
+
+// How long the cluster keeps the scroll context between pages
+const scrollKeepAlive = "2m"
+
+func (b *esBackend) Scroll(request *SearchRequest, page func(*SearchResponse) bool) error {
+	first := *request
+	first.From = 0
+	var raw esSearchResponse
+	err := b.client.perform("POST", searchPath(request.Indices), url.Values{"scroll": {scrollKeepAlive}}, b.searchBody(&first), &raw)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if raw.ScrollId != "" {
+			b.clearScroll(raw.ScrollId)
+		}
+	}()
+	for len(raw.Hits.Hits) > 0 && page(raw.searchResponse()) {
+		scrollId := raw.ScrollId
+		raw = esSearchResponse{}
+		if err := b.nextScrollPage(scrollId, &raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scroll id is passed in the URL to legacy clusters, newer ones take it in the JSON body
+func (b *esBackend) nextScrollPage(scrollId string, raw *esSearchResponse) error {
+	if _, legacy := b.dialect.(*legacyDialect); legacy {
+		return b.client.perform("GET", "/_search/scroll", url.Values{"scroll": {scrollKeepAlive}, "scroll_id": {scrollId}}, nil, raw)
+	}
+	body := map[string]interface{}{"scroll": scrollKeepAlive, "scroll_id": scrollId}
+	return b.client.perform("POST", "/_search/scroll", nil, body, raw)
+}
+
+// Releases the scroll context early, failures are harmless as the context expires anyway
+func (b *esBackend) clearScroll(scrollId string) {
+	var err error
+	if _, legacy := b.dialect.(*legacyDialect); legacy {
+		err = b.client.perform("DELETE", "/_search/scroll/"+url.PathEscape(scrollId), nil, nil, nil)
+	} else {
+		err = b.client.perform("DELETE", "/_search/scroll", nil, map[string]interface{}{"scroll_id": []string{scrollId}}, nil)
+	}
+	if err != nil {
+		Trace.Printf("Failed to clear scroll: %s", err)
+	}
+}
+
+func (b *esBackend) TailPage(request *SearchRequest, timestampField string, cursor *tailCursor) (*SearchResponse, error) {
+	page := *request
+	page.Query.Filters = append([]Filter{}, request.Query.Filters...)
+	if !cursor.isEmpty() {
+		page.Query.Filters = append(page.Query.Filters, RangeFilter{Field: timestampField, Gte: cursor.timestamp})
+	}
+	page.Sort = []SortField{{Field: timestampField, Ascending: true}}
+	return b.Search(&page)
+}
+
+func (b *esBackend) Aggregate(request *SearchRequest) (AggregationResults, error) {
+	aggregations := *request
+	aggregations.Size = 0
+	aggregations.From = 0
+	response, err := b.Search(&aggregations)
+	if err != nil {
+		return nil, err
+	}
+	return response.Aggregations, nil
+}
+
+func (b *esBackend) DescribeRequest(request *SearchRequest) (string, error) {
+	body, err := json.MarshalIndent(b.searchBody(request), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("POST %s\n%s", searchPath(request.Indices), body), nil
+}
+
+func (b *esBackend) ValidateQuery(request *SearchRequest) (string, error) {
+	var result struct {
+		Valid        bool   `json:"valid"`
+		Error        string `json:"error"`
+		Explanations []struct {
+			Index       string `json:"index"`
+			Valid       bool   `json:"valid"`
+			Explanation string `json:"explanation"`
+			Error       string `json:"error"`
+		} `json:"explanations"`
+	}
+	body := map[string]interface{}{"query": b.dialect.query(request.Query)}
+	err := b.client.perform("POST", validatePath(request.Indices), url.Values{"explain": {"true"}}, body, &result)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{fmt.Sprintf("Valid: %t", result.Valid)}
+	if result.Error != "" {
+		lines = append(lines, "Error: "+result.Error)
+	}
+	for _, explanation := range result.Explanations {
+		if explanation.Valid {
+			lines = append(lines, fmt.Sprintf("%s: %s", explanation.Index, explanation.Explanation))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: invalid - %s", explanation.Index, explanation.Error))
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Builds the JSON body of the search request. Sorting on any field is followed by sorting on the tiebreaker field,
+// which makes paging over entries sharing the same sort value stable.
+func (b *esBackend) searchBody(request *SearchRequest) map[string]interface{} {
+	body := map[string]interface{}{
+		"query": b.dialect.query(request.Query),
+		"from":  request.From,
+		"size":  request.Size,
+	}
+	if len(request.SearchAfter) > 0 && b.dialect.supportsSearchAfter() {
+		body["from"] = 0
+		body["search_after"] = request.SearchAfter
+	}
+	if len(request.Sort) > 0 {
+		sorts := make([]interface{}, 0, len(request.Sort)+1)
+		for _, s := range request.Sort {
+			sorts = append(sorts, sortClause(s.Field, s.Ascending))
+		}
+		sorts = append(sorts, sortClause(b.dialect.tiebreakerField(), request.Sort[len(request.Sort)-1].Ascending))
+		body["sort"] = sorts
+	}
+	if len(request.Aggregations) > 0 {
+		body["aggs"] = aggregationsBody(b.dialect, request.Aggregations)
+	}
+	if request.Highlight {
+		//whole fields are returned as single fragments with matches enclosed in the tags
+		body["highlight"] = map[string]interface{}{
+			"pre_tags":            []string{highlightPreTag},
+			"post_tags":           []string{highlightPostTag},
+			"require_field_match": false,
+			"fields":              map[string]interface{}{"*": map[string]interface{}{"number_of_fragments": 0}},
+		}
+	}
+	if modern, ok := b.dialect.(*modernDialect); ok && modern.tracksTotalHits() {
+		body["track_total_hits"] = true
+	}
+	return body
+}
+
+// Tags enclosing matches in highlighted fragments
+const (
+	highlightPreTag  = "@@hl@@"
+	highlightPostTag = "@@/hl@@"
+)
+
+// Extracts matched parts enclosed in highlight tags from fragments of each field
+func highlightedParts(highlight map[string][]string) map[string][]string {
+	if len(highlight) == 0 {
+		return nil
+	}
+	parts := make(map[string][]string, len(highlight))
+	for field, fragments := range highlight {
+		for _, fragment := range fragments {
+			for _, tagged := range strings.Split(fragment, highlightPreTag)[1:] {
+				if end := strings.Index(tagged, highlightPostTag); end > 0 {
+					parts[field] = append(parts[field], tagged[:end])
+				}
+			}
+		}
+	}
+	return parts
+}
+
+func sortClause(field string, ascending bool) map[string]interface{} {
+	order := "desc"
+	if ascending {
+		order = "asc"
+	}
+	return map[string]interface{}{field: map[string]interface{}{"order": order}}
+}
+
+func aggregationsBody(dialect queryDialect, aggregations map[string]Aggregation) map[string]interface{} {
+	body := make(map[string]interface{}, len(aggregations))
+	for name, aggregation := range aggregations {
+		body[name] = dialect.aggregation(aggregation)
+	}
+	return body
+}
+
+func searchPath(indices []string) string {
+	if len(indices) == 0 {
+		return "/_search"
+	}
+	return "/" + strings.Join(indices, ",") + "/_search"
+}
+
+func mappingPath(indices []string) string {
+	if len(indices) == 0 {
+		return "/_mapping"
+	}
+	return "/" + strings.Join(indices, ",") + "/_mapping"
+}
+
+func validatePath(indices []string) string {
+	if len(indices) == 0 {
+		return "/_validate/query"
+	}
+	return "/" + strings.Join(indices, ",") + "/_validate/query"
+}
+
+// Total hits are a number in older clusters and an object with value in 7.x and newer
+func parseTotalHits(raw json.RawMessage) int64 {
+	var total int64
+	if err := json.Unmarshal(raw, &total); err == nil {
+		return total
+	}
+	var totalObject struct {
+		Value int64 `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &totalObject); err != nil {
+		Trace.Printf("Could not parse total hits %s", raw)
+	}
+	return totalObject.Value
+}
+
+// Translates filters to DSL, negated filters are returned separately so that they can be placed into must_not
+func filterClauses(dialect queryDialect, filters []Filter) (must []interface{}, mustNot []interface{}) {
+	must = []interface{}{}
+	mustNot = []interface{}{}
+	for _, filter := range filters {
+		if not, ok := filter.(NotFilter); ok {
+			mustNot = append(mustNot, filterClause(dialect, not.Filter))
+		} else {
+			must = append(must, filterClause(dialect, filter))
+		}
+	}
+	return must, mustNot
+}
+
+// Filter clauses are the same in all the supported dialects, except for full text queries used as filters
+func filterClause(dialect queryDialect, filter Filter) interface{} {
+	switch f := filter.(type) {
+	case TermsFilter:
+		return map[string]interface{}{"terms": map[string]interface{}{f.Field: f.Values}}
+	case RangeFilter:
+		bounds := map[string]interface{}{}
+		if f.Gt != nil {
+			bounds["gt"] = f.Gt
+		}
+		if f.Gte != nil {
+			bounds["gte"] = f.Gte
+		}
+		if f.Lt != nil {
+			bounds["lt"] = f.Lt
+		}
+		if f.Lte != nil {
+			bounds["lte"] = f.Lte
+		}
+		return map[string]interface{}{"range": map[string]interface{}{f.Field: bounds}}
+	case ExistsFilter:
+		return map[string]interface{}{"exists": map[string]interface{}{"field": f.Field}}
+	case RegexpFilter:
+		return map[string]interface{}{"regexp": map[string]interface{}{f.Field: f.Regexp}}
+	case MatchPhraseFilter:
+		return dialect.queryAsFilter(map[string]interface{}{"match_phrase": map[string]interface{}{f.Field: f.Phrase}})
+	case NotFilter:
+		return map[string]interface{}{"bool": map[string]interface{}{"must_not": []interface{}{filterClause(dialect, f.Filter)}}}
+	}
+	panic(fmt.Sprintf("Unsupported filter %#v", filter))
+}
+
+// Aggregations that are the same in all the supported dialects
+func commonAggregation(aggregation Aggregation) map[string]interface{} {
+	switch a := aggregation.(type) {
+	case MinAggregation:
+		return map[string]interface{}{"min": map[string]interface{}{"field": a.Field}}
+	case MaxAggregation:
+		return map[string]interface{}{"max": map[string]interface{}{"field": a.Field}}
+	case StatsAggregation:
+		return map[string]interface{}{"stats": map[string]interface{}{"field": a.Field}}
+	case PercentilesAggregation:
+		return map[string]interface{}{"percentiles": map[string]interface{}{"field": a.Field, "percents": a.Percents}}
+	case HistogramAggregation:
+		return map[string]interface{}{"histogram": map[string]interface{}{
+			"field":           a.Field,
+			"interval":        a.Interval,
+			"min_doc_count":   0,
+			"extended_bounds": map[string]interface{}{"min": a.Min, "max": a.Max},
+		}}
+	}
+	panic(fmt.Sprintf("Unsupported aggregation %#v", aggregation))
+}
+
+func withSubAggregations(dialect queryDialect, body map[string]interface{},
+	subAggregations map[string]Aggregation) map[string]interface{} {
+	if len(subAggregations) > 0 {
+		body["aggs"] = aggregationsBody(dialect, subAggregations)
+	}
+	return body
+}
+
+// Date histogram with fixed interval, the name of the interval parameter depends on the dialect
+func dateHistogramBody(dialect queryDialect, a DateHistogramAggregation, intervalParameter string) map[string]interface{} {
+	histogram := map[string]interface{}{"field": a.Field, "min_doc_count": 0}
+	histogram[intervalParameter] = intervalString(a.Interval)
+	if !a.Min.IsZero() && !a.Max.IsZero() {
+		histogram["extended_bounds"] = map[string]interface{}{
+			"min": timeToMillis(a.Min),
+			"max": timeToMillis(a.Max),
+		}
+	}
+	return withSubAggregations(dialect, map[string]interface{}{"date_histogram": histogram}, a.SubAggregations)
+}
+
+// Formats interval in the largest whole unit understood by Elasticsearch
+func intervalString(interval time.Duration) string {
+	switch {
+	case interval >= 24*time.Hour && interval%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", interval/(24*time.Hour))
+	case interval >= time.Hour && interval%time.Hour == 0:
+		return fmt.Sprintf("%dh", interval/time.Hour)
+	case interval >= time.Minute && interval%time.Minute == 0:
+		return fmt.Sprintf("%dm", interval/time.Minute)
+	case interval >= time.Second && interval%time.Second == 0:
+		return fmt.Sprintf("%ds", interval/time.Second)
+	}
+	return fmt.Sprintf("%dms", interval/time.Millisecond)
+}
+
+func timeToMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func queryStringClause(queryString string) map[string]interface{} {
+	if queryString == "" {
+		return map[string]interface{}{"match_all": map[string]interface{}{}}
+	}
+	return map[string]interface{}{"query_string": map[string]interface{}{
+		"query":            queryString,
+		"default_field":    "message",
+		"default_operator": "and",
+	}}
+}
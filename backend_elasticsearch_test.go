@@ -0,0 +1,524 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Request received by the fake cluster
+type fakeRequest struct {
+	method string
+	path   string
+	query  map[string][]string
+	body   interface{}
+}
+
+//
+// Fake cluster reporting the given version. Search responses are taken from the searches queue (the last one is
+// repeated), or evaluated over docs if there are any. Scrolls return a single page of one hit.
+//
+type fakeCluster struct {
+	server       *httptest.Server
+	version      string
+	distribution string
+	searches     []string
+	docs         []fakeDoc
+	requests     []fakeRequest
+}
+
+// Entry stored in a shard of the fake cluster, doc is its position within the shard (sort value of _doc)
+type fakeDoc struct {
+	id        string
+	shard     int
+	doc       int
+	timestamp time.Time
+}
+
+func newFakeCluster(t *testing.T, version string, distribution string) *fakeCluster {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	cluster := &fakeCluster{version: version, distribution: distribution}
+	cluster.server = httptest.NewServer(http.HandlerFunc(cluster.handle))
+	t.Cleanup(cluster.server.Close)
+	return cluster
+}
+
+func (c *fakeCluster) handle(w http.ResponseWriter, r *http.Request) {
+	request := fakeRequest{method: r.Method, path: r.URL.Path, query: r.URL.Query()}
+	if content, _ := ioutil.ReadAll(r.Body); len(content) > 0 {
+		json.Unmarshal(content, &request.body)
+	}
+	c.requests = append(c.requests, request)
+
+	switch {
+	case r.URL.Path == "/":
+		info := map[string]interface{}{"number": c.version}
+		if c.distribution != "" {
+			info["distribution"] = c.distribution
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"version": info})
+	case r.URL.Path == "/_aliases":
+		w.Write([]byte(`{"logstash-2024.01.02":{"aliases":{}},"app":{"aliases":{}},"logstash-2024.01.01":{"aliases":{}}}`))
+	case r.URL.Path == "/_search/scroll" && r.Method == "DELETE" || strings.HasPrefix(r.URL.Path, "/_search/scroll/"):
+		w.Write([]byte(`{}`))
+	case r.URL.Path == "/_search/scroll":
+		w.Write([]byte(`{"_scroll_id":"scroll-2","hits":{"total":2,"hits":[]}}`))
+	case r.URL.Query().Get("scroll") != "":
+		w.Write([]byte(`{"_scroll_id":"scroll-1","hits":{"total":2,"hits":[{"_index":"i","_id":"1","_source":{}}]}}`))
+	case len(c.docs) > 0:
+		json.NewEncoder(w).Encode(c.searchDocs(request.body.(map[string]interface{})))
+	default:
+		response := `{"hits":{"total":0,"hits":[]}}`
+		if len(c.searches) > 0 {
+			response = c.searches[0]
+			if len(c.searches) > 1 {
+				c.searches = c.searches[1:]
+			}
+		}
+		w.Write([]byte(response))
+	}
+}
+
+// Evaluates search of the tailer over docs - gte range of @timestamp, sort by @timestamp and the tiebreaker, search_after
+// and from/size. Docs tied on both are ordered by shard, like the cluster merges results of shards.
+func (c *fakeCluster) searchDocs(body map[string]interface{}) map[string]interface{} {
+	tiebreaker := ""
+	for key := range body["sort"].([]interface{})[1].(map[string]interface{}) {
+		tiebreaker = key
+	}
+	sortValues := func(d fakeDoc) []interface{} {
+		if tiebreaker == "_doc" {
+			return []interface{}{float64(timeToMillis(d.timestamp)), float64(d.doc)}
+		}
+		return []interface{}{float64(timeToMillis(d.timestamp)), d.id}
+	}
+	less := func(a []interface{}, b []interface{}) bool {
+		for i := range a {
+			switch av := a[i].(type) {
+			case float64:
+				if bv := b[i].(float64); av != bv {
+					return av < bv
+				}
+			case string:
+				if bv := b[i].(string); av != bv {
+					return av < bv
+				}
+			}
+		}
+		return false
+	}
+
+	var gte time.Time
+	if filters, ok := body["query"].(map[string]interface{})["bool"].(map[string]interface{})["filter"].([]interface{}); ok {
+		for _, filter := range filters {
+			if r, ok := filter.(map[string]interface{})["range"]; ok {
+				gte, _ = time.Parse(time.RFC3339Nano, r.(map[string]interface{})["@timestamp"].(map[string]interface{})["gte"].(string))
+			}
+		}
+	}
+	matched := []fakeDoc{}
+	for _, d := range c.docs {
+		if !d.timestamp.Before(gte) {
+			matched = append(matched, d)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := sortValues(matched[i]), sortValues(matched[j])
+		if less(a, b) || less(b, a) {
+			return less(a, b)
+		}
+		return matched[i].shard < matched[j].shard
+	})
+	if after, ok := body["search_after"].([]interface{}); ok {
+		following := []fakeDoc{}
+		for _, d := range matched {
+			if less(after, sortValues(d)) {
+				following = append(following, d)
+			}
+		}
+		matched = following
+	}
+	from, size := int(body["from"].(float64)), int(body["size"].(float64))
+	hits := []interface{}{}
+	for i := from; i < len(matched) && i < from+size; i++ {
+		d := matched[i]
+		hits = append(hits, map[string]interface{}{
+			"_index":  fmt.Sprintf("logs-%d", d.shard),
+			"_id":     d.id,
+			"sort":    sortValues(d),
+			"_source": map[string]interface{}{"@timestamp": d.timestamp.Format(time.RFC3339Nano), "message": d.id},
+		})
+	}
+	return map[string]interface{}{"hits": map[string]interface{}{"total": map[string]interface{}{"value": len(matched)}, "hits": hits}}
+}
+
+func (c *fakeCluster) backend(t *testing.T) *esBackend {
+	backend, err := NewElasticsearchBackend(c.server.URL, "", "", false)
+	if err != nil {
+		t.Fatalf("Failed to connect to fake cluster %s: %s", c.version, err)
+	}
+	c.requests = nil
+	return backend.(*esBackend)
+}
+
+// Returns the only request received since the backend was created
+func (c *fakeCluster) lastRequest(t *testing.T) fakeRequest {
+	if len(c.requests) != 1 {
+		t.Fatalf("Expected a single request, got %d: %v", len(c.requests), c.requests)
+	}
+	return c.requests[0]
+}
+
+func assertJSON(t *testing.T, name string, actual interface{}, expected string) {
+	t.Helper()
+	var expectedValue, actualValue interface{}
+	if err := json.Unmarshal([]byte(expected), &expectedValue); err != nil {
+		t.Fatalf("Invalid expected JSON of %s: %s", name, err)
+	}
+	content, _ := json.Marshal(actual)
+	json.Unmarshal(content, &actualValue)
+	if !reflect.DeepEqual(actualValue, expectedValue) {
+		t.Errorf("Unexpected %s:\n got: %s\nwant: %s", name, content, expected)
+	}
+}
+
+func TestDialectSelectedByVersion(t *testing.T) {
+	cases := []struct {
+		version      string
+		distribution string
+		name         string
+		legacy       bool
+		tiebreaker   string
+	}{
+		{"1.7.5", "", "Elasticsearch 1.7.5", true, "_uid"},
+		{"2.4.6", "", "Elasticsearch 2.4.6", true, "_uid"},
+		{"5.6.16", "", "Elasticsearch 5.6.16", false, "_uid"},
+		{"6.8.23", "", "Elasticsearch 6.8.23", false, "_uid"},
+		{"7.17.9", "default", "Elasticsearch 7.17.9", false, "_id"},
+		{"8.11.1", "default", "Elasticsearch 8.11.1", false, "_doc"},
+		{"1.3.0", "opensearch", "OpenSearch 1.3.0", false, "_id"},
+		{"2.11.0", "opensearch", "OpenSearch 2.11.0", false, "_id"},
+	}
+	for _, c := range cases {
+		backend := newFakeCluster(t, c.version, c.distribution).backend(t)
+		if backend.Name() != c.name {
+			t.Errorf("%s: expected name %s, got %s", c.version, c.name, backend.Name())
+		}
+		if _, legacy := backend.dialect.(*legacyDialect); legacy != c.legacy {
+			t.Errorf("%s %s: expected legacy dialect %t", c.distribution, c.version, c.legacy)
+		}
+		if tiebreaker := backend.dialect.tiebreakerField(); tiebreaker != c.tiebreaker {
+			t.Errorf("%s %s: expected tiebreaker %s, got %s", c.distribution, c.version, c.tiebreaker, tiebreaker)
+		}
+		//search_after needs unique tiebreaker, index order is unique within a shard only
+		if searchAfter := backend.dialect.supportsSearchAfter(); searchAfter != (c.tiebreaker != "_doc" && !c.legacy) {
+			t.Errorf("%s %s: unexpected search_after support %t", c.distribution, c.version, searchAfter)
+		}
+	}
+}
+
+// Entries written by the tailer
+type collectingWriter struct {
+	messages []string
+}
+
+func (w *collectingWriter) WriteEntry(hit *SearchHit, entry map[string]interface{}) {
+	w.messages = append(w.messages, entry["message"].(string))
+}
+
+// Full pages end on a timestamp shared by entries of two shards, which also share positions within the shards. All the
+// entries have to be tailed exactly once.
+func TestTailPagingOverTiesAcrossShards(t *testing.T) {
+	boundary := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	docs := []fakeDoc{
+		{"a", 0, 0, boundary}, {"b", 1, 0, boundary}, {"c", 0, 1, boundary}, {"d", 1, 1, boundary},
+		{"e", 0, 2, boundary}, {"f", 1, 2, boundary}, {"g", 0, 3, boundary.Add(time.Second)},
+		{"h", 1, 3, boundary.Add(time.Second)}, {"i", 0, 4, boundary.Add(2 * time.Second)},
+	}
+	for _, version := range []string{"2.4.6", "7.17.9", "8.11.1"} {
+		cluster := newFakeCluster(t, version, "")
+		if version == "2.4.6" {
+			continue //legacy query DSL is not evaluated by the fake cluster
+		}
+		cluster.docs = docs
+		writer := &collectingWriter{}
+		tail := &Tail{backend: cluster.backend(t), queryDefinition: &QueryDefinition{TimestampField: "@timestamp"},
+			order: true, output: writer}
+		for _, pageSize := range []int{3, 2, 4} {
+			tail.cursor = newTailCursor()
+			tail.cursor.advance(boundary.Add(-time.Second).Format(time.RFC3339Nano), "logs-0/#z")
+			writer.messages = nil
+			if _, err := tail.drainNewEntries(pageSize, 0); err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(writer.messages)
+			if joined := strings.Join(writer.messages, ""); joined != "abcdefghi" {
+				t.Errorf("%s: page size %d tailed entries %s, expected abcdefghi", version, pageSize, joined)
+			}
+		}
+	}
+}
+
+func TestIndexNames(t *testing.T) {
+	for _, version := range []string{"2.4.6", "7.17.9"} {
+		cluster := newFakeCluster(t, version, "")
+		names, err := cluster.backend(t).IndexNames()
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := cluster.lastRequest(t)
+		if request.method != "GET" || request.path != "/_aliases" {
+			t.Errorf("%s: unexpected request %s %s", version, request.method, request.path)
+		}
+		expected := []string{"app", "logstash-2024.01.01", "logstash-2024.01.02"}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("%s: expected indices %v, got %v", version, expected, names)
+		}
+	}
+}
+
+func searchRequest() *SearchRequest {
+	return &SearchRequest{
+		Indices: []string{"logstash-2024.01.01", "logstash-2024.01.02"},
+		Query: Query{QueryString: "error", Filters: []Filter{
+			TermsFilter{Field: "source", Values: []string{"api"}},
+			NotFilter{Filter: MatchPhraseFilter{Field: "host", Phrase: "web 1"}},
+		}},
+		Sort: []SortField{{Field: "@timestamp", Ascending: false}},
+		Size: 50,
+	}
+}
+
+func TestSearchBody(t *testing.T) {
+	cases := []struct {
+		version  string
+		expected string
+	}{
+		{"2.4.6", `{
+			"query": {"filtered": {
+				"query": {"query_string": {"query": "error", "default_field": "message", "default_operator": "and"}},
+				"filter": {"bool": {
+					"must": [{"terms": {"source": ["api"]}}],
+					"must_not": [{"query": {"match_phrase": {"host": "web 1"}}}]
+				}}
+			}},
+			"from": 0, "size": 50,
+			"sort": [{"@timestamp": {"order": "desc"}}, {"_uid": {"order": "desc"}}]
+		}`},
+		{"7.17.9", `{
+			"query": {"bool": {
+				"must": [{"query_string": {"query": "error", "default_field": "message", "default_operator": "and"}}],
+				"filter": [{"terms": {"source": ["api"]}}],
+				"must_not": [{"match_phrase": {"host": "web 1"}}]
+			}},
+			"from": 0, "size": 50,
+			"sort": [{"@timestamp": {"order": "desc"}}, {"_id": {"order": "desc"}}],
+			"track_total_hits": true
+		}`},
+	}
+	for _, c := range cases {
+		cluster := newFakeCluster(t, c.version, "")
+		cluster.searches = []string{`{"hits":{"total":{"value":3,"relation":"eq"},"hits":[
+			{"_index":"logstash-2024.01.02","_type":"_doc","_id":"a","sort":[1704153600123,"a"],"_source":{"message":"error"}}
+		]}}`}
+		response, err := cluster.backend(t).Search(searchRequest())
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := cluster.lastRequest(t)
+		if request.method != "POST" || request.path != "/logstash-2024.01.01,logstash-2024.01.02/_search" {
+			t.Errorf("%s: unexpected request %s %s", c.version, request.method, request.path)
+		}
+		assertJSON(t, c.version+" search body", request.body, c.expected)
+		if response.TotalHits != 3 || len(response.Hits) != 1 || response.Hits[0].Uid() != "logstash-2024.01.02/_doc#a" {
+			t.Errorf("%s: unexpected response %+v", c.version, response)
+		}
+	}
+}
+
+func TestTailPageBody(t *testing.T) {
+	cursor := newTailCursor()
+	cursor.advance("2024-01-02T00:00:00.123Z", "logstash-2024.01.02/_doc#a")
+	after := []interface{}{json.RawMessage("1704153600123"), json.RawMessage(`"a"`)}
+	cases := []struct {
+		version  string
+		expected string
+	}{
+		//search_after is not supported, entries sharing the boundary timestamp are skipped using offset
+		{"2.4.6", `{
+			"query": {"filtered": {
+				"query": {"match_all": {}},
+				"filter": {"bool": {"must": [{"range": {"@timestamp": {"gte": "2024-01-02T00:00:00.123Z"}}}], "must_not": []}}
+			}},
+			"from": 100, "size": 100,
+			"sort": [{"@timestamp": {"order": "asc"}}, {"_uid": {"order": "asc"}}]
+		}`},
+		{"6.8.23", `{
+			"query": {"bool": {
+				"must": [{"match_all": {}}],
+				"filter": [{"range": {"@timestamp": {"gte": "2024-01-02T00:00:00.123Z"}}}],
+				"must_not": []
+			}},
+			"from": 0, "size": 100, "search_after": [1704153600123, "a"],
+			"sort": [{"@timestamp": {"order": "asc"}}, {"_uid": {"order": "asc"}}]
+		}`},
+		{"7.17.9", `{
+			"query": {"bool": {
+				"must": [{"match_all": {}}],
+				"filter": [{"range": {"@timestamp": {"gte": "2024-01-02T00:00:00.123Z"}}}],
+				"must_not": []
+			}},
+			"from": 0, "size": 100, "search_after": [1704153600123, "a"],
+			"sort": [{"@timestamp": {"order": "asc"}}, {"_id": {"order": "asc"}}],
+			"track_total_hits": true
+		}`},
+	}
+	for _, c := range cases {
+		cluster := newFakeCluster(t, c.version, "")
+		request := &SearchRequest{Indices: []string{"logstash-2024.01.02"}, From: 100, SearchAfter: after, Size: 100}
+		if _, err := cluster.backend(t).TailPage(request, "@timestamp", cursor); err != nil {
+			t.Fatal(err)
+		}
+		assertJSON(t, c.version+" tail page body", cluster.lastRequest(t).body, c.expected)
+	}
+}
+
+// Sort values are passed back to search_after exactly, long values must not be rounded to float
+func TestSortValuesKeptExact(t *testing.T) {
+	cluster := newFakeCluster(t, "7.17.9", "")
+	cluster.searches = []string{`{"hits":{"total":{"value":1},"hits":[
+		{"_index":"i","_id":"a","sort":[1704153600123456789,"a"],"_source":{}}
+	]}}`}
+	backend := cluster.backend(t)
+	response, err := backend.Search(&SearchRequest{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(backend.searchBody(&SearchRequest{Size: 1, SearchAfter: response.Hits[0].Sort}))
+	if !strings.Contains(string(body), `"search_after":[1704153600123456789,"a"]`) {
+		t.Errorf("Sort values changed: %s", body)
+	}
+}
+
+func TestAggregationBody(t *testing.T) {
+	min := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	request := &SearchRequest{
+		Indices: []string{"logstash-2024.01.01"},
+		From:    20,
+		Size:    20,
+		Aggregations: map[string]Aggregation{
+			"sources": TermsAggregation{Field: "source", Size: 10, OrderByKey: true},
+			"histogram": DateHistogramAggregation{Field: "@timestamp", Interval: time.Hour, Min: min, Max: min.Add(24 * time.Hour),
+				SubAggregations: map[string]Aggregation{"status": StatsAggregation{Field: "status"}}},
+		},
+	}
+	cases := []struct {
+		version      string
+		distribution string
+		expected     string
+	}{
+		{"2.4.6", "", `{
+			"sources": {"terms": {"field": "source", "size": 10, "order": {"_term": "asc"}}},
+			"histogram": {"date_histogram": {"field": "@timestamp", "min_doc_count": 0, "interval": "1h",
+				"extended_bounds": {"min": 1704067200000, "max": 1704153600000}},
+				"aggs": {"status": {"stats": {"field": "status"}}}}
+		}`},
+		{"5.6.16", "", `{
+			"sources": {"terms": {"field": "source", "size": 10, "order": {"_term": "asc"}}},
+			"histogram": {"date_histogram": {"field": "@timestamp", "min_doc_count": 0, "interval": "1h",
+				"extended_bounds": {"min": 1704067200000, "max": 1704153600000}},
+				"aggs": {"status": {"stats": {"field": "status"}}}}
+		}`},
+		{"7.17.9", "", `{
+			"sources": {"terms": {"field": "source", "size": 10, "order": {"_key": "asc"}}},
+			"histogram": {"date_histogram": {"field": "@timestamp", "min_doc_count": 0, "interval": "1h",
+				"extended_bounds": {"min": 1704067200000, "max": 1704153600000}},
+				"aggs": {"status": {"stats": {"field": "status"}}}}
+		}`},
+		{"8.11.1", "", `{
+			"sources": {"terms": {"field": "source", "size": 10, "order": {"_key": "asc"}}},
+			"histogram": {"date_histogram": {"field": "@timestamp", "min_doc_count": 0, "fixed_interval": "1h",
+				"extended_bounds": {"min": 1704067200000, "max": 1704153600000}},
+				"aggs": {"status": {"stats": {"field": "status"}}}}
+		}`},
+		{"2.11.0", "opensearch", `{
+			"sources": {"terms": {"field": "source", "size": 10, "order": {"_key": "asc"}}},
+			"histogram": {"date_histogram": {"field": "@timestamp", "min_doc_count": 0, "fixed_interval": "1h",
+				"extended_bounds": {"min": 1704067200000, "max": 1704153600000}},
+				"aggs": {"status": {"stats": {"field": "status"}}}}
+		}`},
+	}
+	for _, c := range cases {
+		cluster := newFakeCluster(t, c.version, c.distribution)
+		cluster.searches = []string{`{"hits":{"total":5,"hits":[]},"aggregations":{"sources":{"buckets":[{"key":"api","doc_count":5}]}}}`}
+		results, err := cluster.backend(t).Aggregate(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := cluster.lastRequest(t).body.(map[string]interface{})
+		assertJSON(t, c.version+" aggregations", body["aggs"], c.expected)
+		if body["size"] != 0.0 || body["from"] != 0.0 {
+			t.Errorf("%s: aggregations should not fetch hits, got size %v from %v", c.version, body["size"], body["from"])
+		}
+		if buckets, ok := results.Buckets("sources"); !ok || len(buckets) != 1 || buckets[0].KeyString() != "api" {
+			t.Errorf("%s: unexpected aggregation results %s", c.version, results["sources"])
+		}
+	}
+}
+
+func TestScrollRequests(t *testing.T) {
+	cases := []struct {
+		version string
+		next    fakeRequest
+		clear   fakeRequest
+	}{
+		//scroll id goes in the URL to legacy clusters
+		{"2.4.6",
+			fakeRequest{method: "GET", path: "/_search/scroll", query: map[string][]string{"scroll": {"2m"}, "scroll_id": {"scroll-1"}}},
+			fakeRequest{method: "DELETE", path: "/_search/scroll/scroll-2", query: map[string][]string{}}},
+		{"7.17.9",
+			fakeRequest{method: "POST", path: "/_search/scroll", query: map[string][]string{},
+				body: map[string]interface{}{"scroll": "2m", "scroll_id": "scroll-1"}},
+			fakeRequest{method: "DELETE", path: "/_search/scroll", query: map[string][]string{},
+				body: map[string]interface{}{"scroll_id": []interface{}{"scroll-2"}}}},
+	}
+	for _, c := range cases {
+		cluster := newFakeCluster(t, c.version, "")
+		backend := cluster.backend(t)
+		request := &SearchRequest{Indices: []string{"logstash-2024.01.01"}, From: 10, Size: 500,
+			Sort: []SortField{{Field: "@timestamp", Ascending: true}}}
+		pages := 0
+		err := backend.Scroll(request, func(page *SearchResponse) bool {
+			pages++
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pages != 1 {
+			t.Errorf("%s: expected 1 page, got %d", c.version, pages)
+		}
+		if len(cluster.requests) != 3 {
+			t.Fatalf("%s: expected search, scroll and clear requests, got %v", c.version, cluster.requests)
+		}
+		first := cluster.requests[0]
+		if first.method != "POST" || first.path != "/logstash-2024.01.01/_search" || first.query["scroll"][0] != "2m" {
+			t.Errorf("%s: unexpected initial scroll request %+v", c.version, first)
+		}
+		if from := first.body.(map[string]interface{})["from"]; from != 0.0 {
+			t.Errorf("%s: scroll should start from 0, got %v", c.version, from)
+		}
+		for i, expected := range []fakeRequest{c.next, c.clear} {
+			if actual := cluster.requests[i+1]; !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%s: unexpected request\n got: %+v\nwant: %+v", c.version, actual, expected)
+			}
+		}
+	}
+}
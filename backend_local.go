@@ -0,0 +1,594 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File name standing for standard input in --file option, and the index name its entries get
+const (
+	localStdinFile  = "-"
+	localStdinIndex = "stdin"
+)
+
+// Dialect local requests are described in by --explain. Filters and aggregations mean the same as in the query DSL.
+var localDescribeDialect = &modernDialect{major: 7}
+
+// Entry read from a local file
+type localEntry struct {
+	hit       *SearchHit
+	entry     map[string]interface{}
+	timestamp time.Time //zero if the entry has no valid timestamp
+	position  int       //order in which the entry was read, breaks ties when sorting
+}
+
+//
+// Backend searching NDJSON files (archived logs, exports) or standard input instead of a cluster, for offline use.
+// Each file is an index. All the entries are loaded into memory when the backend is created and the query, sorting and
+// aggregations are evaluated on the client side the way the cluster would evaluate them.
+//
+type localBackend struct {
+	indices        []string
+	timestampField string
+	entries        []*localEntry //sorted by timestamp
+	queries        map[string]queryNode
+}
+
+// Loads the files, gzip-compressed files are decompressed. Lines that are not JSON objects are skipped, as well as
+// the metadata header of exports.
+func NewLocalBackend(files []string, timestampField string) (LogBackend, error) {
+	b := &localBackend{timestampField: timestampField, queries: make(map[string]queryNode)}
+	for _, file := range files {
+		if err := b.load(file); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(b.entries, func(i, j int) bool {
+		return b.entries[i].timestamp.Before(b.entries[j].timestamp)
+	})
+	for i, e := range b.entries {
+		e.position = i
+	}
+	Info.Printf("Loaded %d entries from %s", len(b.entries), strings.Join(b.indices, ", "))
+	return b, nil
+}
+
+func (b *localBackend) load(file string) error {
+	index := file
+	var input io.Reader
+	if file == localStdinFile {
+		index = localStdinIndex
+		input = os.Stdin
+	} else {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+	reader := bufio.NewReader(input)
+	if magic, _ := reader.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		decompressed, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("Failed to decompress %s: %s", file, err)
+		}
+		defer decompressed.Close()
+		reader = bufio.NewReader(decompressed)
+	}
+
+	b.indices = append(b.indices, index)
+	skipped := 0
+	for number := 1; ; number++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("Failed to read %s: %s", file, err)
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			var entry map[string]interface{}
+			if json.Unmarshal(line, &entry) != nil {
+				skipped++
+			} else if _, header := entry["_export"]; !header || len(entry) > 1 {
+				b.addEntry(index, number, line, entry)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if skipped > 0 {
+		Info.Printf("Skipped %d lines of %s that are not JSON objects", skipped, file)
+	}
+	return nil
+}
+
+func (b *localBackend) addEntry(index string, number int, line []byte, entry map[string]interface{}) {
+	e := &localEntry{
+		hit:   &SearchHit{Index: index, Id: strconv.Itoa(number), Source: json.RawMessage(line)},
+		entry: entry,
+	}
+	if timestamp, ok := parseTimestamp(entry[b.timestampField]); ok {
+		e.timestamp = timestamp
+	}
+	b.entries = append(b.entries, e)
+}
+
+func (b *localBackend) Name() string {
+	return fmt.Sprintf("Local files (%d entries)", len(b.entries))
+}
+
+func (b *localBackend) IndexNames() ([]string, error) {
+	return b.indices, nil
+}
+
+func (b *localBackend) Search(request *SearchRequest) (*SearchResponse, error) {
+	matched, err := b.matching(request)
+	if err != nil {
+		return nil, err
+	}
+	response := &SearchResponse{TotalHits: int64(len(matched))}
+	if len(request.Aggregations) > 0 {
+		response.Aggregations = localAggregations(request.Aggregations, matched)
+	}
+	b.sortEntries(matched, request.Sort)
+	for i := request.From; i < len(matched) && i < request.From+request.Size; i++ {
+		response.Hits = append(response.Hits, matched[i].hit)
+	}
+	return response, nil
+}
+
+func (b *localBackend) TailPage(request *SearchRequest, timestampField string, cursor *tailCursor) (*SearchResponse, error) {
+	page := *request
+	page.Query.Filters = append([]Filter{}, request.Query.Filters...)
+	if !cursor.isEmpty() {
+		page.Query.Filters = append(page.Query.Filters, RangeFilter{Field: timestampField, Gte: cursor.timestamp})
+	}
+	page.Sort = []SortField{{Field: timestampField, Ascending: true}}
+	return b.Search(&page)
+}
+
+func (b *localBackend) Scroll(request *SearchRequest, page func(*SearchResponse) bool) error {
+	matched, err := b.matching(request)
+	if err != nil {
+		return err
+	}
+	b.sortEntries(matched, request.Sort)
+	size := request.Size
+	if size <= 0 {
+		size = batchScrollPageSize
+	}
+	for start := 0; start < len(matched); start += size {
+		response := &SearchResponse{TotalHits: int64(len(matched))}
+		for i := start; i < len(matched) && i < start+size; i++ {
+			response.Hits = append(response.Hits, matched[i].hit)
+		}
+		if !page(response) {
+			break
+		}
+	}
+	return nil
+}
+
+func (b *localBackend) Aggregate(request *SearchRequest) (AggregationResults, error) {
+	aggregations := *request
+	aggregations.Size = 0
+	aggregations.From = 0
+	response, err := b.Search(&aggregations)
+	if err != nil {
+		return nil, err
+	}
+	return response.Aggregations, nil
+}
+
+func (b *localBackend) DescribeRequest(request *SearchRequest) (string, error) {
+	must, mustNot := filterClauses(localDescribeDialect, request.Query.Filters)
+	description := map[string]interface{}{
+		"query_string": request.Query.QueryString,
+		"filter":       must,
+		"must_not":     mustNot,
+		"from":         request.From,
+		"size":         request.Size,
+	}
+	if len(request.Sort) > 0 {
+		sorts := make([]interface{}, 0, len(request.Sort))
+		for _, s := range request.Sort {
+			sorts = append(sorts, sortClause(s.Field, s.Ascending))
+		}
+		description["sort"] = sorts
+	}
+	if len(request.Aggregations) > 0 {
+		description["aggs"] = aggregationsBody(localDescribeDialect, request.Aggregations)
+	}
+	body, err := json.MarshalIndent(description, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Evaluated locally over %s\n%s", strings.Join(request.Indices, ", "), body), nil
+}
+
+func (b *localBackend) ValidateQuery(request *SearchRequest) (string, error) {
+	if _, err := b.parseQuery(request.Query.QueryString); err != nil {
+		return fmt.Sprintf("Valid: false\nError: %s", err), nil
+	}
+	return fmt.Sprintf("Valid: true\nTerms without field prefix are searched in %s field", queryDefaultField), nil
+}
+
+// Fields are inferred from the values in the entries. Timestamps are dates, strings are matched both as tokens and
+// exactly, so they are reported as keywords.
+func (b *localBackend) FieldMappings(indices []string) (map[string]*FieldMapping, error) {
+	fields := make(map[string]*FieldMapping)
+	included := stringSet(indices)
+	for _, e := range b.entries {
+		if included[e.hit.Index] {
+			collectLocalFields(fields, "", e.entry)
+		}
+	}
+	return fields, nil
+}
+
+func collectLocalFields(fields map[string]*FieldMapping, prefix string, object map[string]interface{}) {
+	for name, value := range object {
+		path := prefix + name
+		if nested, ok := value.(map[string]interface{}); ok {
+			collectLocalFields(fields, path+".", nested)
+			continue
+		}
+		if values, ok := value.([]interface{}); ok && len(values) > 0 {
+			value = values[0]
+		}
+		addFieldMapping(fields, &FieldMapping{Path: path, Type: localFieldType(value)})
+	}
+}
+
+func localFieldType(value interface{}) string {
+	switch value.(type) {
+	case string:
+		if _, ok := parseTimestamp(value); ok {
+			return "date"
+		}
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return "object"
+}
+
+// Entries of the requested indices matching the query, in timestamp order
+func (b *localBackend) matching(request *SearchRequest) ([]*localEntry, error) {
+	queryString, err := b.parseQuery(request.Query.QueryString)
+	if err != nil {
+		return nil, err
+	}
+	included := stringSet(request.Indices)
+	matched := []*localEntry{}
+	for _, e := range b.entries {
+		if (len(included) == 0 || included[e.hit.Index]) && request.Query.matchesEntry(queryString, e.entry) {
+			matched = append(matched, e)
+		}
+	}
+	return matched, nil
+}
+
+// Query strings are parsed once, tail and pager repeat the same query many times
+func (b *localBackend) parseQuery(queryString string) (queryNode, error) {
+	if node, ok := b.queries[queryString]; ok {
+		return node, nil
+	}
+	node, err := parseQueryString(queryString)
+	if err != nil {
+		return nil, err
+	}
+	b.queries[queryString] = node
+	return node, nil
+}
+
+// Sorts entries by the sort fields, ties are broken by the order of the entries in the same direction as the last
+// sort field. Entries missing the field are sorted last.
+func (b *localBackend) sortEntries(entries []*localEntry, sortFields []SortField) {
+	if len(sortFields) == 0 {
+		return
+	}
+	if len(sortFields) == 1 && sortFields[0].Field == b.timestampField {
+		//entries are kept in timestamp order already
+		if !sortFields[0].Ascending {
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+		}
+		return
+	}
+	ascending := sortFields[len(sortFields)-1].Ascending
+	sort.Slice(entries, func(i, j int) bool {
+		for _, s := range sortFields {
+			value, ok := lookupField(entries[i].entry, s.Field)
+			other, otherOk := lookupField(entries[j].entry, s.Field)
+			if !ok || !otherOk {
+				if ok != otherOk {
+					return ok
+				}
+				continue
+			}
+			if comparison := compareValues(value, other); comparison != 0 {
+				return comparison < 0 == s.Ascending
+			}
+		}
+		return entries[i].position < entries[j].position == ascending
+	})
+}
+
+// Compares values as numbers, timestamps or strings, in this order of preference
+func compareValues(value interface{}, other interface{}) int {
+	number, isNumber := numericValue(value)
+	otherNumber, otherIsNumber := numericValue(other)
+	switch {
+	case isNumber && otherIsNumber && number < otherNumber:
+		return -1
+	case isNumber && otherIsNumber && number > otherNumber:
+		return 1
+	case isNumber && otherIsNumber:
+		return 0
+	}
+	return strings.Compare(fmt.Sprintf("%v", value), fmt.Sprintf("%v", other))
+}
+
+// Numeric value of numbers, numeric strings and timestamps (as epoch millis, like dates in aggregations)
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		if number, ok := parseFloat(v); ok {
+			return number, true
+		}
+		if timestamp, ok := parseTimestamp(v); ok {
+			return float64(timeToMillis(timestamp)), true
+		}
+	}
+	return 0, false
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// -- Aggregations --
+
+// Computes the aggregations over the entries. Results have the same structure as the responses of the cluster, so
+// they are read the same way.
+func localAggregations(aggregations map[string]Aggregation, entries []*localEntry) AggregationResults {
+	results := make(AggregationResults, len(aggregations))
+	for name, aggregation := range aggregations {
+		encoded, err := json.Marshal(localAggregation(aggregation, entries))
+		if err != nil {
+			Trace.Printf("Failed encoding result of aggregation %s: %s", name, err)
+			continue
+		}
+		results[name] = encoded
+	}
+	return results
+}
+
+func localAggregation(aggregation Aggregation, entries []*localEntry) map[string]interface{} {
+	switch a := aggregation.(type) {
+	case TermsAggregation:
+		return localTerms(a, entries)
+	case DateHistogramAggregation:
+		interval := float64(a.Interval / time.Millisecond)
+		var min, max *float64
+		if !a.Min.IsZero() && !a.Max.IsZero() {
+			minMillis, maxMillis := float64(timeToMillis(a.Min)), float64(timeToMillis(a.Max))
+			min, max = &minMillis, &maxMillis
+		}
+		return localHistogram(a.Field, interval, min, max, a.SubAggregations, entries, func(key float64) interface{} {
+			return millisToTime(key).Format("2006-01-02T15:04:05.000Z")
+		})
+	case HistogramAggregation:
+		return localHistogram(a.Field, a.Interval, &a.Min, &a.Max, nil, entries, nil)
+	case MinAggregation:
+		values := numericFieldValues(entries, a.Field)
+		if len(values) == 0 {
+			return map[string]interface{}{"value": nil}
+		}
+		return map[string]interface{}{"value": values[0]}
+	case MaxAggregation:
+		values := numericFieldValues(entries, a.Field)
+		if len(values) == 0 {
+			return map[string]interface{}{"value": nil}
+		}
+		return map[string]interface{}{"value": values[len(values)-1]}
+	case StatsAggregation:
+		values := numericFieldValues(entries, a.Field)
+		stats := map[string]interface{}{"count": len(values), "min": nil, "max": nil, "avg": nil, "sum": 0}
+		if len(values) > 0 {
+			sum := 0.0
+			for _, value := range values {
+				sum += value
+			}
+			stats["min"], stats["max"], stats["avg"], stats["sum"] = values[0], values[len(values)-1], sum/float64(len(values)), sum
+		}
+		return stats
+	case PercentilesAggregation:
+		values := numericFieldValues(entries, a.Field)
+		percentiles := make(map[string]interface{}, len(a.Percents))
+		for _, percent := range a.Percents {
+			percentiles[strconv.FormatFloat(percent, 'f', 1, 64)] = percentile(values, percent)
+		}
+		return map[string]interface{}{"values": percentiles}
+	}
+	Trace.Printf("Unsupported aggregation %#v", aggregation)
+	return map[string]interface{}{}
+}
+
+// Buckets entries by values of the field. Entries with multiple values (arrays) are counted in each of the buckets.
+func localTerms(a TermsAggregation, entries []*localEntry) map[string]interface{} {
+	type bucket struct {
+		key     interface{}
+		entries []*localEntry
+	}
+	buckets := map[string]*bucket{}
+	for _, e := range entries {
+		counted := map[string]bool{}
+		for _, value := range localFieldValues(e, a.Field) {
+			key := fmt.Sprintf("%v", value)
+			if counted[key] {
+				continue
+			}
+			counted[key] = true
+			if buckets[key] == nil {
+				buckets[key] = &bucket{key: value}
+			}
+			buckets[key].entries = append(buckets[key].entries, e)
+		}
+	}
+
+	sorted := make([]*bucket, 0, len(buckets))
+	for _, b := range buckets {
+		sorted = append(sorted, b)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if !a.OrderByKey && len(sorted[i].entries) != len(sorted[j].entries) {
+			return len(sorted[i].entries) > len(sorted[j].entries)
+		}
+		return compareValues(sorted[i].key, sorted[j].key) < 0
+	})
+	other := 0
+	if a.Size > 0 && len(sorted) > a.Size {
+		for _, b := range sorted[a.Size:] {
+			other += len(b.entries)
+		}
+		sorted = sorted[:a.Size]
+	}
+
+	result := make([]interface{}, 0, len(sorted))
+	for _, b := range sorted {
+		result = append(result, localBucket(b.key, b.entries, a.SubAggregations))
+	}
+	return map[string]interface{}{"buckets": result, "sum_other_doc_count": other}
+}
+
+// Buckets numeric values (or timestamps as epoch millis) by fixed interval. Empty buckets between the first and last
+// bucket are included, extended to the bounds if they are given. Key of a bucket is its lower bound.
+func localHistogram(field string, interval float64, min *float64, max *float64, subAggregations map[string]Aggregation,
+	entries []*localEntry, keyAsString func(float64) interface{}) map[string]interface{} {
+	result := map[string]interface{}{"buckets": []interface{}{}}
+	if interval <= 0 {
+		return result
+	}
+	//buckets are numbered, so that keys are computed the same way for values and the empty buckets between them
+	bucketed := map[int64][]*localEntry{}
+	var first, last int64
+	found := false
+	include := func(number int64) {
+		if !found || number < first {
+			first = number
+		}
+		if !found || number > last {
+			last = number
+		}
+		found = true
+	}
+	for _, e := range entries {
+		for _, value := range localFieldValues(e, field) {
+			if value, ok := numericValue(value); ok {
+				number := int64(math.Floor(value / interval))
+				bucketed[number] = append(bucketed[number], e)
+				include(number)
+			}
+		}
+	}
+	if min != nil && max != nil {
+		include(int64(math.Floor(*min / interval)))
+		include(int64(math.Floor(*max / interval)))
+	}
+	buckets := []interface{}{}
+	for number := first; found && number <= last; number++ {
+		key := float64(number) * interval
+		bucket := localBucket(key, bucketed[number], subAggregations)
+		if keyAsString != nil {
+			bucket["key_as_string"] = keyAsString(key)
+		}
+		buckets = append(buckets, bucket)
+	}
+	result["buckets"] = buckets
+	return result
+}
+
+func localBucket(key interface{}, entries []*localEntry, subAggregations map[string]Aggregation) map[string]interface{} {
+	bucket := map[string]interface{}{"key": key, "doc_count": len(entries)}
+	for name, aggregation := range subAggregations {
+		bucket[name] = localAggregation(aggregation, entries)
+	}
+	if b, ok := key.(bool); ok {
+		//the cluster keys boolean buckets by 1 and 0
+		bucket["key"], bucket["key_as_string"] = 0, "false"
+		if b {
+			bucket["key"], bucket["key_as_string"] = 1, "true"
+		}
+	}
+	return bucket
+}
+
+// Values of the field in the entry, elements of arrays are values on their own. Index of the entry is available as
+// _index field and keyword multi-fields (e.g. host.keyword) have the values of their parent field.
+func localFieldValues(e *localEntry, field string) []interface{} {
+	if field == "_index" {
+		return []interface{}{e.hit.Index}
+	}
+	value, ok := lookupField(e.entry, field)
+	if !ok && strings.HasSuffix(field, ".keyword") {
+		value, ok = lookupField(e.entry, strings.TrimSuffix(field, ".keyword"))
+	}
+	if !ok {
+		return nil
+	}
+	if values, isArray := value.([]interface{}); isArray {
+		return values
+	}
+	return []interface{}{value}
+}
+
+// Numeric values of the field in ascending order
+func numericFieldValues(entries []*localEntry, field string) []float64 {
+	values := []float64{}
+	for _, e := range entries {
+		for _, value := range localFieldValues(e, field) {
+			if number, ok := numericValue(value); ok {
+				values = append(values, number)
+			}
+		}
+	}
+	sort.Float64s(values)
+	return values
+}
+
+// Percentile of sorted values interpolated between the closest ranks, nil if there are no values
+func percentile(values []float64, percent float64) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	rank := percent / 100 * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	if lower >= len(values)-1 {
+		return values[len(values)-1]
+	}
+	return values[lower] + (rank-float64(lower))*(values[lower+1]-values[lower])
+}
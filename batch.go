@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Number of entries fetched per scroll page when printing all entries
+const batchScrollPageSize = 1000
+
+// Entries are printed without prompting when limits are given explicitly or when the tool does not run in an
+// interactive terminal (cron jobs, CI, pipes), so that it never blocks waiting for keys.
+func (c *Configuration) isBatchMode() bool {
+	if c.TailMode {
+		return false
+	}
+	return c.QueryDefinition.Limit > 0 || c.QueryDefinition.All || !isInteractive() ||
+		!terminal.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// Prints entries matching the query without prompting. Without --all these are the entries of the initial search,
+// up to the --limit or -n entries. Returns the number of printed entries.
+func (t *Tail) Batch(entriesPerBatch int) int64 {
+	if t.queryDefinition.All {
+		return t.printAll(t.queryDefinition.Limit)
+	}
+	if t.queryDefinition.Limit > 0 {
+		entriesPerBatch = t.queryDefinition.Limit
+	}
+	result, err := t.initialSearch(entriesPerBatch)
+	if err != nil {
+		Error.Fatalln("Error in executing search query.", err)
+	}
+	return int64(t.processResults(result))
+}
+
+// Scrolls through all entries matching the query in ascending order. Stops after limit entries (0 means no limit).
+func (t *Tail) printAll(limit int) int64 {
+	var printed int64
+	request := t.initialSearchRequest(batchScrollPageSize)
+	request.Sort = []SortField{{Field: t.queryDefinition.TimestampField, Ascending: true}}
+	err := t.backend.Scroll(request, func(page *SearchResponse) bool {
+		for _, hit := range page.Hits {
+			if t.processHit(hit) {
+				printed++
+			}
+			if limit > 0 && printed >= int64(limit) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		Error.Fatalln("Error in executing search query.", err)
+	}
+	return printed
+}
+
+// Prints the number of entries matching the query and returns it
+func (t *Tail) Count() int64 {
+	result, err := t.backend.Search(&SearchRequest{Indices: t.indices, Query: t.buildSearchQuery(), Size: 0})
+	if err != nil {
+		Error.Fatalln("Error in executing search query.", err)
+	}
+	fmt.Println(result.TotalHits)
+	return result.TotalHits
+}
@@ -1,9 +1,21 @@
 package main
 
-import "github.com/fatih/color"
+import (
+	"strings"
+
+	"github.com/fatih/color"
+)
 
 func paintTimestamp(timestamp string) string {
-	return color.GreenString(rightPad2Len(timestamp, " ", 23))
+	return paintPaddedTimestamp(padTimestamp(timestamp))
+}
+
+func padTimestamp(timestamp string) string {
+	return rightPad2Len(timestamp, " ", 23)
+}
+
+func paintPaddedTimestamp(timestamp string) string {
+	return color.GreenString(timestamp)
 }
 
 func paintRequestId(requestId string) string {
@@ -19,6 +31,12 @@ func paintInfoline(content string) string {
 }
 
 func paintSystemParams(config *Configuration) string {
+	if config.isOffline() {
+		return color.MagentaString("Profile: " + config.Profile + " | Files: " + strings.Join(config.Files, ", "))
+	}
+	if config.isListening() {
+		return color.MagentaString("Profile: " + config.Profile + " | Listening: " + strings.Join(config.Listen, ", "))
+	}
 	return color.MagentaString("Profile: " + config.Profile + " | Host: " + config.SearchTarget.Url)
 }
 
@@ -26,3 +44,7 @@ func highlightContent(content string) string {
 	yellow := color.New(color.FgBlue, color.BgCyan).SprintFunc()
 	return yellow(content)
 }
+
+func highlightWatchContent(content string) string {
+	return color.New(color.FgBlack, color.BgYellow).SprintFunc()(content)
+}
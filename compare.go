@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Suffix of the --compare-to offset, e.g. 24h-ago
+const compareOffsetSuffix = "-ago"
+
+// Number of values of each field compared with the baseline
+const compareFieldValues = 50
+
+// Changes of patterns and values seen fewer times than this in both windows are ignored as noise
+const compareMinCount = 5
+
+// Maximum number of lines printed in each section of the report
+const compareShown = 20
+
+// Pattern or field value seen in the current and the baseline window
+type comparison struct {
+	key      string
+	current  int64
+	baseline int64
+}
+
+// Change of the rate in percent, positive if the key is more frequent in the current window
+func (c *comparison) change() float64 {
+	return (float64(c.current) - float64(c.baseline)) * 100 / float64(c.baseline)
+}
+
+// Compares the time window of the query with the same window shifted back by the offset given using --compare-to
+// (e.g. 24h-ago). Message patterns and values of the fields given using --compare-fields which are new, gone or
+// changed in rate by more than the threshold are reported.
+func (t *Tail) CompareTo(offset string) {
+	shift, err := parseDuration(strings.TrimSuffix(offset, compareOffsetSuffix))
+	if err != nil {
+		Error.Fatalln(err)
+	}
+
+	start, end, err := t.queryTimeWindow()
+	if err != nil {
+		Error.Fatalln(err)
+	}
+	current := t.withTimeWindow(start, end)
+	baseline := t.withTimeWindow(start.Add(-shift), end.Add(-shift))
+	fmt.Println(paintInfoline(fmt.Sprintf("Comparing %s with baseline %s", describeWindow(start, end),
+		describeWindow(start.Add(-shift), end.Add(-shift)))))
+
+	currentPatterns := current.collectPatterns()
+	baselinePatterns := baseline.collectPatterns()
+	fmt.Println(paintInfoline(fmt.Sprintf("%d entries in current window, %d entries in baseline window",
+		currentPatterns.entries, baselinePatterns.entries)))
+	printComparison("patterns", patternCounts(currentPatterns), patternCounts(baselinePatterns),
+		t.queryDefinition.CompareThreshold, highlightPattern)
+
+	fields, err := t.queryDefinition.CompareFieldList()
+	if err != nil {
+		Error.Fatalln(err)
+	}
+	for _, field := range fields {
+		currentValues := current.fieldValueCounts(field, nil)
+		baselineValues := baseline.fieldValueCounts(field, nil)
+		//values ranked lower in the other window are counted there as well, so that only values absent from the
+		//other window are reported as new or gone
+		for value, count := range current.fieldValueCounts(field, missingKeys(baselineValues, currentValues)) {
+			currentValues[value] = count
+		}
+		for value, count := range baseline.fieldValueCounts(field, missingKeys(currentValues, baselineValues)) {
+			baselineValues[value] = count
+		}
+		printComparison("values of "+field, currentValues, baselineValues, t.queryDefinition.CompareThreshold, paintSource)
+	}
+}
+
+// Returns copy of the tail querying given time window, with indices resolved for the window
+func (t *Tail) withTimeWindow(start time.Time, end time.Time) *Tail {
+	queryDefinition := *t.queryDefinition
+	queryDefinition.Duration = ""
+	queryDefinition.DurationSpecified = false
+	queryDefinition.AfterDateTime = start.In(localTz).Format(dateTimeLayout)
+	queryDefinition.BeforeDateTime = end.In(localTz).Format(dateTimeLayout)
+
+	indices, err := t.backend.IndexNames()
+	if err != nil {
+		Error.Fatalln("Could not fetch available indices.", err)
+	}
+	windowed := *t
+	windowed.queryDefinition = &queryDefinition
+	windowed.cursor = newTailCursor()
+	windowed.indices = findIndicesForDateRange(indices, t.indexPattern, t.indexNamingScheme(indices), start, end)
+	Info.Printf("Using indices %s for window %s", windowed.indices, describeWindow(start, end))
+	return &windowed
+}
+
+func describeWindow(start time.Time, end time.Time) string {
+	return start.In(localTz).Format("2006-01-02 15:04:05") + " - " + end.In(localTz).Format("2006-01-02 15:04:05")
+}
+
+func patternCounts(collector *patternCollector) map[string]int64 {
+	counts := make(map[string]int64, len(collector.patterns))
+	for pattern, p := range collector.patterns {
+		counts[pattern] = int64(p.count)
+	}
+	return counts
+}
+
+// Counts entries per value of the field for the most frequent values, or for the given values only (none if the list
+// is empty)
+func (t *Tail) fieldValueCounts(field string, values []string) map[string]int64 {
+	request := &SearchRequest{
+		Indices:      t.indices,
+		Query:        t.buildSearchQuery(),
+		Aggregations: map[string]Aggregation{"values": TermsAggregation{Field: field, Size: compareFieldValues}},
+	}
+	if values != nil {
+		if len(values) == 0 {
+			return map[string]int64{}
+		}
+		request.Query.Filters = append(request.Query.Filters, TermsFilter{Field: field, Values: values})
+		request.Aggregations["values"] = TermsAggregation{Field: field, Size: len(values)}
+	}
+	result, err := t.backend.Aggregate(request)
+	if err != nil {
+		Error.Fatalln("Error in executing search query.", err)
+	}
+	buckets, _ := result.Buckets("values")
+	counts := make(map[string]int64, len(buckets))
+	for _, bucket := range buckets {
+		counts[bucket.KeyString()] = bucket.DocCount
+	}
+	return counts
+}
+
+// Keys of counts missing from other
+func missingKeys(counts map[string]int64, other map[string]int64) []string {
+	missing := []string{}
+	for key := range counts {
+		if _, ok := other[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// Prints keys which are new in the current window, gone since the baseline window or whose count changed by more
+// than threshold percent. Both windows have the same length, so counts are compared directly.
+func printComparison(name string, current map[string]int64, baseline map[string]int64, threshold float64,
+	paint func(string) string) {
+	added, gone, changed := compareCounts(current, baseline, threshold)
+	if len(added)+len(gone)+len(changed) == 0 {
+		fmt.Println(paintInfoline(fmt.Sprintf("No significant changes of %s", name)))
+		return
+	}
+	printComparisonSection(fmt.Sprintf("New %s:", name), added, func(c *comparison) string {
+		return fmt.Sprintf("%8d", c.current)
+	}, paint)
+	printComparisonSection(fmt.Sprintf("Gone %s:", name), gone, func(c *comparison) string {
+		return fmt.Sprintf("%8d", c.baseline)
+	}, paint)
+	printComparisonSection(fmt.Sprintf("Changed %s:", name), changed, func(c *comparison) string {
+		return fmt.Sprintf("%8d -> %-8d %+7.0f%%", c.baseline, c.current, c.change())
+	}, paint)
+}
+
+func printComparisonSection(title string, comparisons []*comparison, counts func(*comparison) string,
+	paint func(string) string) {
+	if len(comparisons) == 0 {
+		return
+	}
+	fmt.Println(paintInfoline(title))
+	for i, c := range comparisons {
+		if i == compareShown {
+			fmt.Printf("  ... and %d more\n", len(comparisons)-compareShown)
+			break
+		}
+		fmt.Printf("  %s  %s\n", counts(c), paint(c.key))
+	}
+}
+
+// Splits keys into new, gone and changed ones. New and gone keys are sorted by their count, changed keys by the
+// size of the change.
+func compareCounts(current map[string]int64, baseline map[string]int64, threshold float64) (added, gone, changed []*comparison) {
+	for key, count := range current {
+		c := &comparison{key: key, current: count, baseline: baseline[key]}
+		if c.current < compareMinCount && c.baseline < compareMinCount {
+			continue
+		}
+		if c.baseline == 0 {
+			added = append(added, c)
+		} else if changedBeyond(c, threshold) {
+			changed = append(changed, c)
+		}
+	}
+	for key, count := range baseline {
+		if _, ok := current[key]; !ok && count >= compareMinCount {
+			gone = append(gone, &comparison{key: key, baseline: count})
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].current > added[j].current })
+	sort.Slice(gone, func(i, j int) bool { return gone[i].baseline > gone[j].baseline })
+	sort.Slice(changed, func(i, j int) bool { return math.Abs(changed[i].change()) > math.Abs(changed[j].change()) })
+	return added, gone, changed
+}
+
+// Key changed if it is more frequent by more than threshold percent, or less frequent by the same factor (e.g. with
+// threshold 100 the rate has to double or halve)
+func changedBeyond(c *comparison, threshold float64) bool {
+	factor := 1 + threshold/100
+	ratio := float64(c.current) / float64(c.baseline)
+	return ratio >= factor || ratio <= 1/factor
+}
@@ -8,15 +8,14 @@ import (
 	"github.com/codegangsta/cli"
 	"time"
 	"fmt"
-	"io"
-	"regexp"
-	"strconv"
+	"strings"
 )
 
 type SearchTarget struct {
 	Url          string
 	TunnelUrl    string        `json:"-"`
 	IndexPattern string
+	IndexNaming  string
 }
 
 type QueryDefinition struct {
@@ -27,14 +26,42 @@ type QueryDefinition struct {
 	BeforeDateTime string  `json:"-"`
 	Duration       string
 	Source         string
+	Filters        []string
 	RequestId      string
+	TraceWindow    string  `json:"-"`
 	Watch          string
+	WatchFields    []string `json:"-"`
+	ServerHighlight bool     `json:"-"`
+	Limit          int     `json:"-"`
+	All            bool    `json:"-"`
+	Export         string  `json:"-"`
+	Template       string  `json:"-"`
+	Output         string  `json:"-"`
+	OutputMeta     bool    `json:"-"`
+	HistogramInterval string `json:"-"`
+	HistogramSplit bool    `json:"-"`
+	Sparkline      bool    `json:"-"`
+	TopFields      string  `json:"-"`
+	TopSize        int     `json:"-"`
+	StatsField     string  `json:"-"`
+	StatsGroupBy   string  `json:"-"`
+	CompareTo      string  `json:"-"`
+	CompareFields  string  `json:"-"`
+	CompareThreshold float64 `json:"-"`
 	DurationSpecified bool
 }
 
 type Commands struct {
 	ListSources    bool
 	DefaultProfile bool
+	Explain        bool
+	Trace          bool
+	ValidateQuery  bool
+	Histogram      bool
+	ListFields     bool
+	Patterns       bool
+	Browse         bool
+	Count          bool
 }
 
 type Configuration struct {
@@ -51,12 +78,23 @@ type Configuration struct {
 	TraceRequests   bool        `json:"-"`
 	SSHTunnelParams string
 	SaveQuery       bool        `json:"-"`
+	Templates       map[string]string
+	SaveTemplate    string      `json:"-"`
+	Timezone        string      `json:"-"`
+	WatchActions    WatchActions `json:"-"`
+	AlertRules      string      `json:"-"`
+	Files           []string    `json:"-"`
+	Listen          []string    `json:"-"`
 }
 
 var confDir = ".logstasher"
 
+// Layout of timestamps given using -a and -b options once resolved, in local timezone. The offset is kept, local time
+// alone is ambiguous when clocks are turned back.
+const dateTimeLayout = "2006-01-02T15:04:05.999999999Z07:00"
+
 //When changing this array, make sure to also make appropriate changes in CopyConfigRelevantSettingsTo
-var configRelevantFlags = []string{"url", "f", "i", "u", "ssh"}
+var configRelevantFlags = []string{"url", "f", "i", "index-naming", "u", "ssh"}
 
 
 func userHomeDir() string {
@@ -86,11 +124,18 @@ func (c *Configuration) CopyConfigRelevantSettingsTo(dest *Configuration) {
 	dest.SearchTarget.TunnelUrl = c.SearchTarget.TunnelUrl
 	dest.SearchTarget.Url = c.SearchTarget.Url
 	dest.SearchTarget.IndexPattern = c.SearchTarget.IndexPattern
+	dest.SearchTarget.IndexNaming = c.SearchTarget.IndexNaming
 	dest.QueryDefinition.Format = c.QueryDefinition.Format
 	dest.QueryDefinition.Terms = make([]string, len(c.QueryDefinition.Terms))
 	copy(dest.QueryDefinition.Terms, c.QueryDefinition.Terms)
+	dest.QueryDefinition.Filters = make([]string, len(c.QueryDefinition.Filters))
+	copy(dest.QueryDefinition.Filters, c.QueryDefinition.Filters)
 	dest.User = c.User
 	dest.SSHTunnelParams = c.SSHTunnelParams
+	dest.Templates = make(map[string]string, len(c.Templates))
+	for name, template := range c.Templates {
+		dest.Templates[name] = template
+	}
 }
 
 func (c *Configuration) CopyNonConfigRelevantSettingsTo(dest *Configuration) {
@@ -98,12 +143,35 @@ func (c *Configuration) CopyNonConfigRelevantSettingsTo(dest *Configuration) {
 	dest.QueryDefinition.TimestampField = c.QueryDefinition.TimestampField
 	dest.QueryDefinition.AfterDateTime = c.QueryDefinition.AfterDateTime
 	dest.QueryDefinition.BeforeDateTime = c.QueryDefinition.BeforeDateTime
+	dest.QueryDefinition.TraceWindow = c.QueryDefinition.TraceWindow
+	dest.QueryDefinition.Template = c.QueryDefinition.Template
+	dest.QueryDefinition.Output = c.QueryDefinition.Output
+	dest.QueryDefinition.OutputMeta = c.QueryDefinition.OutputMeta
+	dest.QueryDefinition.HistogramInterval = c.QueryDefinition.HistogramInterval
+	dest.QueryDefinition.HistogramSplit = c.QueryDefinition.HistogramSplit
+	dest.QueryDefinition.Sparkline = c.QueryDefinition.Sparkline
+	dest.QueryDefinition.TopFields = c.QueryDefinition.TopFields
+	dest.QueryDefinition.TopSize = c.QueryDefinition.TopSize
+	dest.QueryDefinition.StatsField = c.QueryDefinition.StatsField
+	dest.QueryDefinition.StatsGroupBy = c.QueryDefinition.StatsGroupBy
+	dest.QueryDefinition.CompareTo = c.QueryDefinition.CompareTo
+	dest.QueryDefinition.CompareFields = c.QueryDefinition.CompareFields
+	dest.QueryDefinition.CompareThreshold = c.QueryDefinition.CompareThreshold
+	dest.QueryDefinition.ServerHighlight = c.QueryDefinition.ServerHighlight
+	dest.QueryDefinition.Limit = c.QueryDefinition.Limit
+	dest.QueryDefinition.All = c.QueryDefinition.All
+	dest.QueryDefinition.Export = c.QueryDefinition.Export
 	dest.TailMode = c.TailMode
 	dest.InitialEntries = c.InitialEntries
 	dest.Password = c.Password
 	dest.Verbose = c.Verbose
 	dest.MoreVerbose = c.MoreVerbose
 	dest.TraceRequests = c.TraceRequests
+	dest.Timezone = c.Timezone
+	dest.WatchActions = c.WatchActions
+	dest.AlertRules = c.AlertRules
+	dest.Files = c.Files
+	dest.Listen = c.Listen
 }
 
 func (c *Configuration) SaveDefault() {
@@ -144,7 +212,7 @@ func LoadProfile(profile string) (conf *Configuration, err error) {
 			return nil, err
 		}
 	}
-	confFile := confDirPath + string(os.PathSeparator) + profile + ".json";
+	confFile := confDirPath + string(os.PathSeparator) + resolveProfileName(profile) + ".json";
 	var config *Configuration
 	confBytes, err := ioutil.ReadFile(confFile)
 	if (err != nil) {
@@ -157,22 +225,47 @@ func LoadProfile(profile string) (conf *Configuration, err error) {
 	return config, nil
 }
 
+// Profile is not loaded when a config relevant flag is given, yet it's saved at the end of the run. Settings that can't
+// be given along with the flags are kept from the existing profile - saved templates (unless saved again under the same
+// name) and index naming scheme (unless given by --index-naming).
+func (c *Configuration) KeepProfileSettings(indexNamingSet bool) {
+	if !profileExists(c.Profile) {
+		return
+	}
+	saved, err := LoadProfile(c.Profile)
+	if err != nil {
+		Info.Printf("Not keeping settings of profile %s: %s\n", c.Profile, err)
+		return
+	}
+	if c.Templates == nil {
+		c.Templates = make(map[string]string, len(saved.Templates))
+	}
+	for name, template := range saved.Templates {
+		if _, ok := c.Templates[name]; !ok {
+			c.Templates[name] = template
+		}
+	}
+	if !indexNamingSet && saved.SearchTarget.IndexNaming != "" {
+		c.SearchTarget.IndexNaming = saved.SearchTarget.IndexNaming
+	}
+}
+
+// Default profile is stored as a reference to the profile, so that it follows later changes of the profile
 func setupDefaultProfile(profile string) {
-	confDirPath := userHomeDir() + string(os.PathSeparator) + confDir;
-	confFile := confDirPath + string(os.PathSeparator) + profile + ".json";
-	targetFile := confDirPath + string(os.PathSeparator) + "default.json";
-
-	if _, err := os.Stat(confFile); err == nil {
-		source, _ := os.Open(confFile)
-		defer source.Close()
-		target, _ := os.Create(targetFile)
-		defer target.Close()
-		_, err = io.Copy(target, source)
-		target.Sync()
-		fmt.Printf("%s setup as default profile. Use -p to override default profile.\n", profile)
-	} else {
+	if _, err := os.Stat(profilePath(profile)); err != nil {
 		Error.Printf("Profile %s does not exist!\n", profile)
+		return
+	}
+	if err := writeDefaultProfileReference(profile); err != nil {
+		Error.Printf("Failed to setup default profile: %s\n", err)
+		return
 	}
+	if profile != defaultProfile {
+		if copied, ok := legacyDefaultCopy(); ok {
+			removeLegacyDefaultCopy(copied)
+		}
+	}
+	fmt.Printf("%s setup as default profile. Use -p to override default profile.\n", profile)
 }
 
 func (config *Configuration) Flags() []cli.Flag {
@@ -202,6 +295,29 @@ func (config *Configuration) Flags() []cli.Flag {
 			Usage:       "(*) Message format for the entries - field names are referenced using % sign, for example '%@timestamp %message'",
 			Destination: &config.QueryDefinition.Format,
 		},
+		cli.StringFlag{
+			Name:        "template",
+			Value:       "",
+			Usage:       "Format entries using Go text/template instead of message format ('{{field . \"@timestamp\" | localtime}} {{.source | pad 20}} {{.message}}'). Functions: field, pad, trunc, default, upper, lower, json, localtime, color, duration. Use @name to refer to a template saved in the profile",
+			Destination: &config.QueryDefinition.Template,
+		},
+		cli.StringFlag{
+			Name:        "save-template",
+			Value:       "",
+			Usage:       "Save template given using --template in the profile under given name (--template '...' --save-template short)",
+			Destination: &config.SaveTemplate,
+		},
+		cli.StringFlag{
+			Name:        "o,output",
+			Value:       OutputText,
+			Usage:       "Output format - text (message format with colors), json (_source of each entry per line), csv, tsv or logfmt (columns are the fields referenced in message format)",
+			Destination: &config.QueryDefinition.Output,
+		},
+		cli.BoolFlag{
+			Name:        "output-meta",
+			Usage:       "Include _index and _id of each entry in json output",
+			Destination: &config.QueryDefinition.OutputMeta,
+		},
 		cli.StringFlag{
 			Name:        "i,index-pattern",
 			Value:       "logstash-[0-9].*",
@@ -209,6 +325,13 @@ func (config *Configuration) Flags() []cli.Flag {
 			Destination: &config.SearchTarget.IndexPattern,
 			Hidden: true,
 		},
+		cli.StringFlag{
+			Name:        "index-naming",
+			Value:       IndexNamingDaily,
+			Usage:       "(*) Index naming scheme used to find indices for a time range - daily, hourly, weekly, monthly, layout:<go time layout> (e.g. layout:2006-01) or cluster (asks the cluster for time span of each index, for aliases and data streams)",
+			Destination: &config.SearchTarget.IndexNaming,
+			Hidden: true,
+		},
 		cli.StringFlag{
 			Name:        "ts,timestamp-field",
 			Value:       "@timestamp",
@@ -227,47 +350,211 @@ func (config *Configuration) Flags() []cli.Flag {
 			Usage:       "Number of entries fetched initially",
 			Destination: &config.InitialEntries,
 		},
+		cli.BoolFlag{
+			Name:        "tui",
+			Usage:       "Browse entries in a full screen terminal UI with query bar, detail pane and follow mode",
+			Destination: &config.Commands.Browse,
+		},
+		cli.IntFlag{
+			Name:        "limit",
+			Usage:       "Print at most this many entries and exit without prompting (batch mode)",
+			Destination: &config.QueryDefinition.Limit,
+		},
+		cli.BoolFlag{
+			Name:        "all",
+			Usage:       "Print all entries matching the query and exit without prompting (batch mode)",
+			Destination: &config.QueryDefinition.All,
+		},
+		cli.BoolFlag{
+			Name:        "count",
+			Usage:       "Print only the number of entries matching the query. Exit code is 0 if there are any, 1 if there are none",
+			Destination: &config.Commands.Count,
+		},
+		cli.StringFlag{
+			Name:        "export",
+			Usage:       "Export all entries matching the query to gzip-compressed NDJSON file with a metadata header. Interrupted export is resumed when run again",
+			Destination: &config.QueryDefinition.Export,
+		},
+		cli.StringSliceFlag{
+			Name:        "file",
+			Usage:       "Search local NDJSON file instead of ElasticSearch (offline mode), can be repeated. Gzip-compressed files such as exports are read as well, - reads standard input (--file day.ndjson.gz -s AuthService)",
+		},
+		cli.StringSliceFlag{
+			Name:        "listen",
+			Usage:       "Print events pushed by Logstash as they arrive instead of searching ElasticSearch, can be repeated. Accepts json_lines over tcp or udp and http POST (--listen :5000, --listen udp://:5000, --listen http://localhost:8080)",
+		},
 		cli.BoolFlag{
 			Name:        "list-sources",
 			Usage:       "List all the application sources",
 			Destination: &config.Commands.ListSources,
 		},
+		cli.BoolFlag{
+			Name:        "fields",
+			Usage:       "List fields of the indices with their types and sample values from the most recent matching entries",
+			Destination: &config.Commands.ListFields,
+		},
+		cli.BoolFlag{
+			Name:        "explain",
+			Usage:       "Print resolved indices, time window and the search request that would be sent to ElasticSearch, without fetching any logs",
+			Destination: &config.Commands.Explain,
+		},
+		cli.BoolFlag{
+			Name:        "explain-validate",
+			Usage:       "Same as --explain but also let ElasticSearch validate the query and explain how it is interpreted",
+			Destination: &config.Commands.ValidateQuery,
+		},
+		cli.StringFlag{
+			Name:        "top",
+			Usage:       "List the most frequent values of the field among matching entries, a second field breaks down each value (--top host, --top host,status)",
+			Destination: &config.QueryDefinition.TopFields,
+		},
+		cli.IntFlag{
+			Name:        "top-size",
+			Value:       10,
+			Usage:       "Number of values listed by --top for each field",
+			Destination: &config.QueryDefinition.TopSize,
+		},
+		cli.StringFlag{
+			Name:        "stats",
+			Usage:       "Print count, min, avg, p50, p95, p99, max and distribution of the numeric field among matching entries (--stats duration)",
+			Destination: &config.QueryDefinition.StatsField,
+		},
+		cli.StringFlag{
+			Name:        "stats-by",
+			Usage:       "Group statistics printed by --stats by the most frequent values of the field (--stats duration --stats-by source)",
+			Destination: &config.QueryDefinition.StatsGroupBy,
+		},
+		cli.BoolFlag{
+			Name:        "patterns",
+			Usage:       "Cluster messages of all matching entries into patterns with variable parts (numbers, ids, IPs, quoted strings) masked, sorted by frequency",
+			Destination: &config.Commands.Patterns,
+		},
+		cli.StringFlag{
+			Name:        "compare-to",
+			Usage:       "Compare the time window with the same window in the past and report new, gone and changed message patterns (-d 1h --compare-to 24h-ago)",
+			Destination: &config.QueryDefinition.CompareTo,
+		},
+		cli.StringFlag{
+			Name:        "compare-fields",
+			Usage:       "Also compare values of the fields separated by comma (--compare-to 24h-ago --compare-fields host,status)",
+			Destination: &config.QueryDefinition.CompareFields,
+		},
+		cli.Float64Flag{
+			Name:        "compare-threshold",
+			Value:       100,
+			Usage:       "Change of rate in percent reported by --compare-to, 100 reports patterns and values whose rate doubled or halved",
+			Destination: &config.QueryDefinition.CompareThreshold,
+		},
+		cli.BoolFlag{
+			Name:        "histogram",
+			Usage:       "Print number of entries matching the query per time interval as a bar chart",
+			Destination: &config.Commands.Histogram,
+		},
+		cli.StringFlag{
+			Name:        "interval",
+			Usage:       "Interval of the histogram (--interval 30s, --interval 1h, --interval 1d). Chosen automatically from the time window by default",
+			Destination: &config.QueryDefinition.HistogramInterval,
+		},
+		cli.BoolFlag{
+			Name:        "sparkline",
+			Usage:       "Print the histogram as a single line sparkline",
+			Destination: &config.QueryDefinition.Sparkline,
+		},
+		cli.BoolFlag{
+			Name:        "split-by-source",
+			Usage:       "Print one sparkline per source in the histogram (top 10 sources)",
+			Destination: &config.QueryDefinition.HistogramSplit,
+		},
 		cli.StringFlag{
 			Name:        "s,src",
 			Value:       "",
 			Usage:       "Show only logs of given source(s) (-s 'AuthService', -s 'AuthService,ReportingService')",
 			Destination: &config.QueryDefinition.Source,
 		},
+		cli.StringSliceFlag{
+			Name:        "F,filter",
+			Usage:       "Filter by field, can be repeated (-F 'status>=500' -F 'host=web-3'). Supported filters: field=value, field!=value, field>=N, field<=N, field>N, field<N, field~regex, field!~regex, field:* (exists) and !field:* (missing)",
+		},
 		cli.StringFlag{
 			Name:        "id",
 			Value:       "",
 			Usage:       "Filter by x-request-id",
 			Destination: &config.QueryDefinition.RequestId,
 		},
+		cli.BoolFlag{
+			Name:        "trace",
+			Usage:       "Reconstruct timeline of the request given using -id option - entries grouped by source and host with elapsed times (-id 4cbff9a3-5ea9-4c8b-9d8e-6a1c1fc7b1c2 --trace)",
+			Destination: &config.Commands.Trace,
+		},
+		cli.StringFlag{
+			Name:        "trace-window",
+			Value:       "24h",
+			Usage:       "Time window searched for the traced request, in the same format as duration, or 'all' to search all indices",
+			Destination: &config.QueryDefinition.TraceWindow,
+		},
+		cli.StringFlag{
+			Name:        "tz",
+			Usage:       "Timezone used for times given without offset and for displayed times instead of the local one (--tz UTC, --tz Europe/Berlin)",
+			Destination: &config.Timezone,
+		},
 		cli.StringFlag{
 			Name:        "a,after",
 			Value:       "",
-			Usage:       "List entries after specified timestamp (-a '2016-11-10T10:01:23.200')",
+			Usage:       "List entries after specified time - timestamp in local timezone or with offset (-a '2016-11-10T10:01:23.200', -a '2016-11-10 10:01:23+01:00'), epoch seconds or millis, or relative time (-a now-2h, -a today, -a 'yesterday 14:00')",
 			Destination: &config.QueryDefinition.AfterDateTime,
 		},
 		cli.StringFlag{
 			Name:        "b,before",
 			Value:       "",
-			Usage:       "List entries before specified timestamp (-b '2016-11-10T10:01:23.200')",
+			Usage:       "List entries before specified time, in the same formats as -a (-b '2016-11-10T10:01:23.200', -b now-1h)",
 			Destination: &config.QueryDefinition.BeforeDateTime,
 		},
 		cli.StringFlag{
 			Name:        "d,duration",
 			Value:       "5m",
-			Usage:       "Display logs for past duration. Must be of the form '%m or %d or %h where % is a number' to give duration in mins, hours or days",
+			Usage:       "Display logs for past duration - numbers followed by units ms, s, m, h, d or w, units can be combined (-d 15m, -d 1h30m, -d 2w)",
 			Destination: &config.QueryDefinition.Duration,
 		},
 		cli.StringFlag{
 			Name:        "w,watch",
 			Value:       "",
-			Usage:       "Watch for word/phrase in the logs and highlight them",
+			Usage:       "Watch for word/phrase in the logs and highlight them, regular expressions are enclosed in slashes (-w '/OutOfMemory|StackOverflow/')",
 			Destination: &config.QueryDefinition.Watch,
 		},
+		cli.BoolFlag{
+			Name:        "es-highlight",
+			Usage:       "Let ElasticSearch report matches of the keywords, so that also matches found by analysis (e.g. stemming) are highlighted",
+			Destination: &config.QueryDefinition.ServerHighlight,
+		},
+		cli.StringSliceFlag{
+			Name:        "watch-field",
+			Usage:       "Watch for entries passing the field filter, can be repeated (--watch-field 'status>=500'). Same syntax as -F",
+		},
+		cli.BoolFlag{
+			Name:        "watch-bell",
+			Usage:       "Ring the terminal bell when an entry matches the watch rules",
+			Destination: &config.WatchActions.Bell,
+		},
+		cli.StringFlag{
+			Name:        "watch-exec",
+			Usage:       "Run shell command with the matching entry as JSON on its standard input (--watch-exec 'notify-send OOM')",
+			Destination: &config.WatchActions.Command,
+		},
+		cli.StringFlag{
+			Name:        "watch-file",
+			Usage:       "Append matching entries as JSON lines to the file",
+			Destination: &config.WatchActions.File,
+		},
+		cli.StringFlag{
+			Name:        "alerts",
+			Usage:       "File with threshold alert rules evaluated in tail mode. Defaults to ~/.logstasher/<profile>.alerts.json if it exists",
+			Destination: &config.AlertRules,
+		},
+		cli.IntFlag{
+			Name:        "watch-exit",
+			Usage:       "Exit with code 3 after given number of entries matching the watch rules",
+			Destination: &config.WatchActions.ExitAfter,
+		},
 		cli.BoolFlag{
 			Name:        "save",
 			Usage:       "Save query terms - next invocation of logstasher (without parameters) will use saved query terms. Any additional terms specified will be applied with AND operator to saved terms",
@@ -316,6 +603,21 @@ func (c *Configuration) isTailMode() bool {
 	return c.TailMode
 }
 
+//entries are read from local files given using --file option instead of ElasticSearch
+func (c *Configuration) isOffline() bool {
+	return len(c.Files) > 0
+}
+
+//entries are received from Logstash on addresses given using --listen option instead of searching ElasticSearch
+func (c *Configuration) isListening() bool {
+	return len(c.Listen) > 0
+}
+
+//profile, credentials and tunnel are needed only when talking to ElasticSearch
+func (c *Configuration) usesCluster() bool {
+	return !c.isOffline() && !c.isListening()
+}
+
 func (q *QueryDefinition) IsDateTimeFiltered() bool {
 	return q.AfterDateTime != "" || q.BeforeDateTime != "" || q.Duration != ""
 }
@@ -324,6 +626,50 @@ func (q *QueryDefinition) isSourceFiltered() bool {
 	return q.Source != ""
 }
 
+// Parses filters given using -F option
+func (q *QueryDefinition) FieldFilters() ([]Filter, error) {
+	filters := make([]Filter, 0, len(q.Filters))
+	for _, expression := range q.Filters {
+		filter, err := ParseFieldFilter(expression)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// Parses fields given using --top option
+func (q *QueryDefinition) TopFieldList() ([]string, error) {
+	fields := []string{}
+	for _, field := range strings.Split(q.TopFields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 || len(fields) > topMaxFields {
+		return nil, fmt.Errorf("Please specify one or two fields separated by comma using --top option (--top host,status)")
+	}
+	if q.TopSize < 1 {
+		return nil, fmt.Errorf("Number of values given using --top-size must be positive")
+	}
+	return fields, nil
+}
+
+// Parses fields given using --compare-fields option
+func (q *QueryDefinition) CompareFieldList() ([]string, error) {
+	fields := []string{}
+	for _, field := range strings.Split(q.CompareFields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	if q.CompareThreshold <= 0 {
+		return nil, fmt.Errorf("Threshold given using --compare-threshold must be positive")
+	}
+	return fields, nil
+}
+
 func (q *QueryDefinition) isRequestIdFiltered() bool {
 	return q.RequestId != ""
 }
@@ -337,47 +683,48 @@ func (q *QueryDefinition) BeforeDateTimeInUTC() string {
 }
 
 func (q *QueryDefinition) SetDurationAsAfterDateTime() {
-	mins := durationToMins(q.Duration)
-	Info.Printf("Using duration in mins: %d\n", mins)
-	now := time.Now()
-	then := now.Add(time.Duration(-mins) * time.Minute)
-	q.AfterDateTime = then.Format("2006-01-02T15:04:05.99999999")
-}
-
-func durationToMins(duration string) int64 {
-	minPattern := regexp.MustCompile(`(\d+)m`)
-	hourPattern := regexp.MustCompile(`(\d+)h`)
-	dayPattern := regexp.MustCompile(`(\d+)d`)
-
-	if res := minPattern.FindStringSubmatch(duration); res != nil {
-		return stringToInt(res[1])
-	} else if res := hourPattern.FindStringSubmatch(duration); res != nil {
-		return stringToInt(res[1]) * 60
-	} else if res := dayPattern.FindStringSubmatch(duration); res != nil {
-		return stringToInt(res[1]) * 60 * 24
-	} else {
-		panic("Unknown duration format. Supported formats: %m,%h,%d where % can 1-9")
+	duration, err := parseDuration(q.Duration)
+	if err != nil {
+		Error.Fatalln(err)
 	}
+	Info.Printf("Using duration: %s\n", duration)
+	then := time.Now().In(localTz).Add(-duration)
+	q.AfterDateTime = then.Format(dateTimeLayout)
 }
 
-func stringToInt(str string) int64 {
-	num, err := strconv.ParseInt(str, 0, 32)
-	if err == nil {
-		return num
-	} else {
-		return 0
+// Validates durations and resolves time expressions given using -a and -b options (e.g. now-2h or epoch millis) to
+// timestamps in local timezone, so that relative expressions don't move while the query is repeated.
+func (q *QueryDefinition) ResolveTimeExpressions(now time.Time) error {
+	if q.Duration != "" {
+		if _, err := parseDuration(q.Duration); err != nil {
+			return err
+		}
+	}
+	if q.TraceWindow != "" && q.TraceWindow != TraceWindowAll {
+		if _, err := parseDuration(q.TraceWindow); err != nil {
+			return err
+		}
+	}
+	for _, dateTime := range []*string{&q.AfterDateTime, &q.BeforeDateTime} {
+		if *dateTime == "" {
+			continue
+		}
+		parsed, err := parseTimeExpression(*dateTime, now, localTz)
+		if err != nil {
+			return err
+		}
+		*dateTime = parsed.In(localTz).Format(dateTimeLayout)
 	}
+	return nil
 }
 
 func parseTimeToUTC(givenTime string) string {
-	parsedTime, timeErr := time.ParseInLocation("2006-01-02T15:04:05.99999999", givenTime, localTz)
-	if timeErr == nil {
-		return parsedTime.UTC().Format(time.RFC3339Nano)
-	} else {
-		fmt.Println("after timestamp not is required format: ", givenTime)
-		fmt.Println(timeErr)
+	parsedTime, err := parseTimeExpression(givenTime, time.Now(), localTz)
+	if err != nil {
+		Error.Println(err)
 		return ""
 	}
+	return parsedTime.UTC().Format(time.RFC3339Nano)
 }
 
 func IsConfigRelevantFlagSet(c *cli.Context) bool {
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// Profile saved by a run with a config relevant flag (-f, --index-naming...), which doesn't load the profile
+func TestProfileSettingsKeptWithConfigRelevantFlags(t *testing.T) {
+	cases := []struct {
+		name              string
+		templates         map[string]string //templates saved during the run
+		indexNaming       string            //index naming after parsing flags
+		indexNamingSet    bool
+		expectedTemplates map[string]string
+		expectedNaming    string
+	}{
+		{"format given", nil, IndexNamingDaily, false,
+			map[string]string{"short": "{{.message}}", "full": "{{.host}} {{.message}}"}, IndexNamingCluster},
+		{"template saved", map[string]string{"short": "{{.level}}"}, IndexNamingDaily, false,
+			map[string]string{"short": "{{.level}}", "full": "{{.host}} {{.message}}"}, IndexNamingCluster},
+		{"index naming given", nil, IndexNamingHourly, true,
+			map[string]string{"short": "{{.message}}", "full": "{{.host}} {{.message}}"}, IndexNamingHourly},
+	}
+	for _, c := range cases {
+		t.Setenv("HOME", t.TempDir())
+		InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+		os.Mkdir(profilesDir(), 0700) //not the first profile, which would be set up as default
+		saved := &Configuration{Profile: "prod", Templates: map[string]string{"short": "{{.message}}", "full": "{{.host}} {{.message}}"}}
+		saved.SearchTarget.Url = "http://localhost:9200"
+		saved.SearchTarget.IndexNaming = IndexNamingCluster
+		saved.SaveDefault()
+
+		config := &Configuration{Profile: "prod", Templates: c.templates}
+		config.SearchTarget.Url = "http://localhost:9200"
+		config.SearchTarget.IndexNaming = c.indexNaming
+		config.QueryDefinition.Format = "%message"
+		config.KeepProfileSettings(c.indexNamingSet)
+		config.Copy().SaveDefault()
+
+		loaded, err := LoadProfile("prod")
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if !reflect.DeepEqual(loaded.Templates, c.expectedTemplates) {
+			t.Errorf("%s: expected templates %v, got %v", c.name, c.expectedTemplates, loaded.Templates)
+		}
+		if loaded.SearchTarget.IndexNaming != c.expectedNaming {
+			t.Errorf("%s: expected index naming %s, got %s", c.name, c.expectedNaming, loaded.SearchTarget.IndexNaming)
+		}
+		if loaded.QueryDefinition.Format != "%message" {
+			t.Errorf("%s: format %s was not saved", c.name, loaded.QueryDefinition.Format)
+		}
+	}
+}
+
+// Nothing to keep when the profile is created by the run
+func TestProfileSettingsKeptWithoutProfile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	config := &Configuration{Profile: "prod"}
+	config.SearchTarget.IndexNaming = IndexNamingDaily
+	config.KeepProfileSettings(false)
+	if len(config.Templates) != 0 || config.SearchTarget.IndexNaming != IndexNamingDaily {
+		t.Errorf("Unexpected settings kept: %v %s", config.Templates, config.SearchTarget.IndexNaming)
+	}
+	if profileExists("prod") {
+		t.Errorf("Profile was created")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+//
+// Cursor that remembers how far the tailer got in the log stream.
+//
+// Entries are fetched sorted by timestamp and then by a tiebreaker (_uid on clusters before 7.x, _id on 7.x and
+// OpenSearch, index order on Elasticsearch 8.x), so the position is fully described by the last seen timestamp
+// together with the uids of all entries seen at that exact timestamp. Each pass over new entries starts with the
+// boundary timestamp included (gte instead of gt), so that entries indexed late with the boundary timestamp are picked
+// up, and the cursor filters out entries that were already printed. Following pages of the pass continue after the
+// sort values of the last hit (search_after) when the tiebreaker is unique, otherwise they skip over the entries of the
+// pass sharing the boundary timestamp (from offset). Either way entries sharing the last timestamp are never lost nor
+// printed twice.
+//
+type tailCursor struct {
+	timestamp string          //timestamp of the last seen entry
+	seen      map[string]bool //uids of entries seen at the boundary timestamp
+}
+
+func newTailCursor() *tailCursor {
+	return &tailCursor{seen: make(map[string]bool)}
+}
+
+// Returns true if no entry was registered with the cursor yet.
+func (c *tailCursor) isEmpty() bool {
+	return c.timestamp == ""
+}
+
+// Registers an entry with the cursor. Entries must be registered in ascending timestamp order. Returns false if the
+// entry was already seen at the boundary timestamp and should be skipped.
+func (c *tailCursor) advance(timestamp string, uid string) bool {
+	if timestamp == c.timestamp {
+		if c.seen[uid] {
+			return false
+		}
+		c.seen[uid] = true
+		return true
+	}
+	c.timestamp = timestamp
+	c.seen = map[string]bool{uid: true}
+	return true
+}
@@ -0,0 +1,48 @@
+package main
+
+//
+// Query DSL of Elasticsearch 1.x and 2.x. Filters are applied using filtered query, which was removed in 5.x.
+//
+type legacyDialect struct{}
+
+func (d *legacyDialect) query(query Query) map[string]interface{} {
+	queryString := queryStringClause(query.QueryString)
+	if len(query.Filters) == 0 {
+		return queryString
+	}
+	must, mustNot := filterClauses(d, query.Filters)
+	return map[string]interface{}{"filtered": map[string]interface{}{
+		"query": queryString,
+		"filter": map[string]interface{}{"bool": map[string]interface{}{
+			"must":     must,
+			"must_not": mustNot,
+		}},
+	}}
+}
+
+func (d *legacyDialect) aggregation(aggregation Aggregation) map[string]interface{} {
+	switch a := aggregation.(type) {
+	case TermsAggregation:
+		terms := map[string]interface{}{"field": a.Field, "size": a.Size}
+		if a.OrderByKey {
+			terms["order"] = map[string]interface{}{"_term": "asc"}
+		}
+		return withSubAggregations(d, map[string]interface{}{"terms": terms}, a.SubAggregations)
+	case DateHistogramAggregation:
+		return dateHistogramBody(d, a, "interval")
+	}
+	return commonAggregation(aggregation)
+}
+
+func (d *legacyDialect) tiebreakerField() string {
+	return "_uid"
+}
+
+func (d *legacyDialect) supportsSearchAfter() bool {
+	return false
+}
+
+// Queries can only be used as filters when wrapped in query filter in 1.x
+func (d *legacyDialect) queryAsFilter(query map[string]interface{}) interface{} {
+	return map[string]interface{}{"query": query}
+}
@@ -0,0 +1,80 @@
+package main
+
+//
+// Query DSL of Elasticsearch 5.x and newer and OpenSearch. Filters are applied in filter context of bool query.
+//
+type modernDialect struct {
+	major      int  //major version of the cluster
+	opensearch bool //true if the cluster is OpenSearch
+}
+
+func (d *modernDialect) query(query Query) map[string]interface{} {
+	queryString := queryStringClause(query.QueryString)
+	if len(query.Filters) == 0 {
+		return queryString
+	}
+	must, mustNot := filterClauses(d, query.Filters)
+	return map[string]interface{}{"bool": map[string]interface{}{
+		"must":     []interface{}{queryString},
+		"filter":   must,
+		"must_not": mustNot,
+	}}
+}
+
+func (d *modernDialect) aggregation(aggregation Aggregation) map[string]interface{} {
+	switch a := aggregation.(type) {
+	case TermsAggregation:
+		terms := map[string]interface{}{"field": a.Field, "size": a.Size}
+		if a.OrderByKey {
+			terms["order"] = map[string]interface{}{d.keyOrder(): "asc"}
+		}
+		return withSubAggregations(d, map[string]interface{}{"terms": terms}, a.SubAggregations)
+	case DateHistogramAggregation:
+		return dateHistogramBody(d, a, d.intervalParameter())
+	}
+	return commonAggregation(aggregation)
+}
+
+// _uid was removed in 7.x in favour of _id. Sorting on _id needs fielddata, which Elasticsearch disables by default
+// since 8.x, so ties are broken by index order there. Index order is unique within a shard only, see
+// supportsSearchAfter.
+func (d *modernDialect) tiebreakerField() string {
+	switch {
+	case d.major >= 8 && !d.opensearch:
+		return "_doc"
+	case d.major >= 7 || d.opensearch:
+		return "_id"
+	}
+	return "_uid"
+}
+
+// Entries in different shards may share both the timestamp and the index order, search_after would skip all but one
+// of them. Pages are fetched using offset when ties are broken by index order.
+func (d *modernDialect) supportsSearchAfter() bool {
+	return d.tiebreakerField() != "_doc"
+}
+
+func (d *modernDialect) queryAsFilter(query map[string]interface{}) interface{} {
+	return query
+}
+
+// Total hits are only counted up to 10000 by default since 7.x
+func (d *modernDialect) tracksTotalHits() bool {
+	return d.major >= 7 || d.opensearch
+}
+
+// Interval of date histogram was split into fixed_interval and calendar_interval in 7.2, the old one was removed in 8.x
+func (d *modernDialect) intervalParameter() string {
+	if d.major >= 8 || d.opensearch {
+		return "fixed_interval"
+	}
+	return "interval"
+}
+
+// Terms are ordered by _key since 6.x
+func (d *modernDialect) keyOrder() string {
+	if d.major >= 6 || d.opensearch {
+		return "_key"
+	}
+	return "_term"
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//
+// Minimal HTTP client for Elasticsearch/OpenSearch REST API. Request and response bodies are JSON, the query DSL
+// is produced by the backend dialects.
+//
+type esClient struct {
+	url           string
+	user          string
+	password      string
+	traceRequests bool
+	httpClient    *http.Client
+}
+
+// Error returned by the cluster
+type esError struct {
+	Status int
+	Body   string
+}
+
+func (e *esError) Error() string {
+	return fmt.Sprintf("elasticsearch returned status %d: %s", e.Status, e.Body)
+}
+
+// Cluster info returned by the root endpoint
+type esClusterInfo struct {
+	Version struct {
+		Number       string `json:"number"`
+		Distribution string `json:"distribution"`
+	} `json:"version"`
+	Tagline string `json:"tagline"`
+}
+
+func newESClient(url string, user string, password string, traceRequests bool) *esClient {
+	return &esClient{
+		url:           strings.TrimRight(url, "/"),
+		user:          user,
+		password:      password,
+		traceRequests: traceRequests,
+		httpClient:    &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// Performs the request and unmarshals the JSON response into result (unless result is nil).
+func (c *esClient) perform(method string, path string, params url.Values, body interface{}, result interface{}) error {
+	requestUrl := c.url + path
+	if len(params) > 0 {
+		requestUrl += "?" + params.Encode()
+	}
+
+	var bodyBytes []byte
+	if body != nil {
+		var err error
+		bodyBytes, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequest(method, requestUrl, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.user != "" {
+		request.SetBasicAuth(c.user, c.password)
+	}
+	if c.traceRequests {
+		Trace.Printf("Request: %s %s\n%s", method, requestUrl, bodyBytes)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if c.traceRequests {
+		Trace.Printf("Response: %s\n%s", response.Status, responseBytes)
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &esError{Status: response.StatusCode, Body: string(responseBytes)}
+	}
+	if result != nil {
+		return json.Unmarshal(responseBytes, result)
+	}
+	return nil
+}
+
+// Fetches cluster info (version and distribution)
+func (c *esClient) clusterInfo() (*esClusterInfo, error) {
+	info := new(esClusterInfo)
+	err := c.perform("GET", "/", nil, nil, info)
+	return info, err
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Prints out everything the tailer would do to fetch the initial batch of entries - resolved indices, time window in
+// UTC and the search request with query and sort - without fetching any entries. If validate is true, the backend
+// is also asked to validate the query and explain how it is interpreted.
+func (t *Tail) Explain(entriesPerBatch int, validate bool) {
+	request := t.initialSearchRequest(entriesPerBatch)
+
+	fmt.Println(paintInfoline("Backend:"), t.backend.Name())
+	fmt.Println(paintInfoline("Indices:"), strings.Join(t.indices, ", "))
+	fmt.Println(paintInfoline("Time window (UTC):"), t.describeTimeWindow())
+	if t.tailMode {
+		fmt.Println(paintInfoline("Tail mode:"), "follow up requests add a range filter on", t.queryDefinition.TimestampField,
+			"starting at the timestamp of the last entry")
+	}
+
+	description, err := t.backend.DescribeRequest(request)
+	if err != nil {
+		Error.Fatalln("Failed to describe search request.", err)
+	}
+	fmt.Println(paintInfoline("Search request:"))
+	fmt.Println(description)
+
+	if validate {
+		validation, err := t.backend.ValidateQuery(request)
+		if err != nil {
+			Error.Fatalln("Failed to validate search query.", err)
+		}
+		fmt.Println(paintInfoline("Query validation:"))
+		fmt.Println(validation)
+	}
+}
+
+func (t *Tail) describeTimeWindow() string {
+	if !t.queryDefinition.IsDateTimeFiltered() {
+		return "not filtered"
+	}
+	after := "-"
+	if t.queryDefinition.AfterDateTime != "" {
+		after = t.queryDefinition.AfterDateTimeInUTC()
+	}
+	before := "now"
+	if t.queryDefinition.BeforeDateTime != "" {
+		before = t.queryDefinition.BeforeDateTimeInUTC()
+	}
+	return after + " - " + before
+}
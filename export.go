@@ -0,0 +1,234 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Suffix of the checkpoint file written next to the export, e.g. day.ndjson.gz.checkpoint
+const exportCheckpointSuffix = ".checkpoint"
+
+// Metadata written as the first line of the export, so that the dump describes where it came from
+type exportHeader struct {
+	Profile   string   `json:"profile"`
+	Host      string   `json:"host"`
+	Query     []string `json:"query"`
+	Source    string   `json:"source,omitempty"`
+	Filters   []string `json:"filters,omitempty"`
+	RequestId string   `json:"request_id,omitempty"`
+	After     string   `json:"after,omitempty"`
+	Before    string   `json:"before,omitempty"`
+	Indices   []string `json:"indices"`
+	Started   string   `json:"started"`
+	Version   string   `json:"version"`
+}
+
+// Progress of an interrupted export. The file is valid up to Size bytes, entries following the cursor (timestamp and
+// uids seen at the timestamp) are yet to be exported.
+type exportCheckpoint struct {
+	Header    *exportHeader `json:"header"`
+	Size      int64         `json:"size"`
+	Exported  int64         `json:"exported"`
+	Timestamp string        `json:"timestamp"`
+	Seen      []string      `json:"seen"`
+}
+
+// Exports all entries matching the query to gzip-compressed NDJSON file, starting with a metadata header line. Entries
+// are fetched in ascending order using scroll. Each page is written as a separate gzip member followed by a checkpoint,
+// so that an interrupted export continues where it stopped when run again with the same query.
+func (t *Tail) Export(path string, profile string, host string) {
+	checkpointPath := path + exportCheckpointSuffix
+	header := t.exportHeader(profile, host)
+	checkpoint, err := loadExportCheckpoint(checkpointPath)
+	if err != nil {
+		Error.Fatalln(err)
+	}
+
+	if checkpoint != nil {
+		if !checkpoint.Header.sameQuery(header) {
+			Error.Fatalf("Checkpoint %s belongs to an export of a different query, remove it to start over\n", checkpointPath)
+		}
+		fmt.Fprintln(InfoOutput, paintInfoline(fmt.Sprintf("Resuming export of %s after %d entries (%s)", path,
+			checkpoint.Exported, checkpoint.Timestamp)))
+		if err := os.Truncate(path, checkpoint.Size); err != nil {
+			Error.Fatalf("Failed to resume export to %s: %s\n", path, err)
+		}
+	} else {
+		if _, err := os.Stat(path); err == nil {
+			Error.Fatalf("File %s already exists, remove it or choose another file\n", path)
+		}
+		checkpoint = &exportCheckpoint{Header: header}
+	}
+
+	exported := t
+	if checkpoint.Header.After != "" {
+		//window is fixed when the export starts, so that relative windows (e.g. -d 24h) don't move on resume
+		start, _ := time.Parse(time.RFC3339Nano, checkpoint.Header.After)
+		end, _ := time.Parse(time.RFC3339Nano, checkpoint.Header.Before)
+		exported = t.withTimeWindow(start, end)
+	}
+	checkpoint.Header.Indices = exported.indices
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		Error.Fatalf("Failed to open %s: %s\n", path, err)
+	}
+	defer file.Close()
+
+	if checkpoint.Size == 0 {
+		line, _ := json.Marshal(map[string]interface{}{"_export": checkpoint.Header})
+		if err := writeGzipMember(file, [][]byte{append(line, '\n')}); err != nil {
+			Error.Fatalf("Failed to write %s: %s\n", path, err)
+		}
+		saveExportCheckpoint(checkpointPath, file, checkpoint)
+	}
+	exported.exportEntries(file, checkpointPath, checkpoint)
+
+	if err := os.Remove(checkpointPath); err != nil {
+		Error.Println("Failed to remove checkpoint.", err)
+	}
+	fmt.Fprintln(InfoOutput, paintInfoline(fmt.Sprintf("Exported %d entries to %s", checkpoint.Exported, path)))
+}
+
+// Scrolls through the entries following the checkpoint and writes them page by page
+func (t *Tail) exportEntries(file *os.File, checkpointPath string, checkpoint *exportCheckpoint) {
+	t.cursor = newTailCursor()
+	t.cursor.timestamp = checkpoint.Timestamp
+	for _, uid := range checkpoint.Seen {
+		t.cursor.seen[uid] = true
+	}
+	request := t.initialSearchRequest(batchScrollPageSize)
+	request.Sort = []SortField{{Field: t.queryDefinition.TimestampField, Ascending: true}}
+	if !t.cursor.isEmpty() {
+		request.Query.Filters = append(request.Query.Filters, RangeFilter{Field: t.queryDefinition.TimestampField, Gte: t.cursor.timestamp})
+	}
+
+	var total int64 = -1
+	err := t.backend.Scroll(request, func(page *SearchResponse) bool {
+		if total < 0 {
+			total = checkpoint.Exported + page.TotalHits - int64(len(checkpoint.Seen))
+		}
+		lines := make([][]byte, 0, len(page.Hits))
+		for _, hit := range page.Hits {
+			var entry map[string]interface{}
+			if err := json.Unmarshal(hit.Source, &entry); err != nil {
+				Error.Fatalln("Failed parsing ElasticSearch response.", err)
+			}
+			timestamp, _ := entry[t.queryDefinition.TimestampField].(string)
+			if t.cursor.advance(timestamp, hit.Uid()) {
+				lines = append(lines, entryJSON(hit, entry))
+			}
+		}
+		if err := writeGzipMember(file, lines); err != nil {
+			Error.Fatalf("Failed to write %s: %s\n", file.Name(), err)
+		}
+		checkpoint.Exported += int64(len(lines))
+		checkpoint.Timestamp = t.cursor.timestamp
+		checkpoint.Seen = checkpoint.Seen[:0]
+		for uid := range t.cursor.seen {
+			checkpoint.Seen = append(checkpoint.Seen, uid)
+		}
+		saveExportCheckpoint(checkpointPath, file, checkpoint)
+		printExportProgress(checkpoint.Exported, total)
+		return true
+	})
+	if terminal.IsTerminal(int(os.Stderr.Fd())) {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		Error.Fatalln("Error in executing search query, run the export again to resume.", err)
+	}
+}
+
+func (t *Tail) exportHeader(profile string, host string) *exportHeader {
+	q := t.queryDefinition
+	header := &exportHeader{
+		Profile:   profile,
+		Host:      host,
+		Query:     q.Terms,
+		Source:    q.Source,
+		Filters:   q.Filters,
+		RequestId: q.RequestId,
+		Started:   time.Now().UTC().Format(time.RFC3339),
+		Version:   VERSION,
+	}
+	if start, end, err := t.queryTimeWindow(); err == nil {
+		header.After = start.UTC().Format(time.RFC3339Nano)
+		header.Before = end.UTC().Format(time.RFC3339Nano)
+	}
+	return header
+}
+
+// Time window is not compared, the window of the checkpoint is used when resuming
+func (h *exportHeader) sameQuery(other *exportHeader) bool {
+	return h.Profile == other.Profile && h.Host == other.Host && reflect.DeepEqual(h.Query, other.Query) &&
+		h.Source == other.Source && reflect.DeepEqual(h.Filters, other.Filters) && h.RequestId == other.RequestId
+}
+
+// Writes the lines as a complete gzip member. Concatenated members form a valid gzip file.
+func writeGzipMember(file *os.File, lines [][]byte) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	writer := gzip.NewWriter(file)
+	for _, line := range lines {
+		if _, err := writer.Write(line); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// Returns nil if there is no checkpoint
+func loadExportCheckpoint(path string) (*exportCheckpoint, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read checkpoint %s: %s", path, err)
+	}
+	checkpoint := new(exportCheckpoint)
+	if err := json.Unmarshal(content, checkpoint); err != nil || checkpoint.Header == nil {
+		return nil, fmt.Errorf("Checkpoint %s is corrupted, remove it together with the export to start over", path)
+	}
+	return checkpoint, nil
+}
+
+// Checkpoint is written only after the data is synced, and replaced atomically
+func saveExportCheckpoint(path string, file *os.File, checkpoint *exportCheckpoint) {
+	if err := file.Sync(); err != nil {
+		Error.Fatalf("Failed to write %s: %s\n", file.Name(), err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		Error.Fatalf("Failed to write %s: %s\n", file.Name(), err)
+	}
+	checkpoint.Size = info.Size()
+	content, _ := json.Marshal(checkpoint)
+	if err := ioutil.WriteFile(path+".tmp", content, 0644); err != nil {
+		Error.Fatalf("Failed to write checkpoint %s: %s\n", path, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		Error.Fatalf("Failed to write checkpoint %s: %s\n", path, err)
+	}
+}
+
+// Progress goes to stderr, updated in place when it is a terminal
+func printExportProgress(exported int64, total int64) {
+	if !terminal.IsTerminal(int(os.Stderr.Fd())) {
+		return
+	}
+	if total > 0 {
+		fmt.Fprintf(os.Stderr, "\rExported %d of %d entries (%.0f%%)", exported, total, float64(exported)*100/float64(total))
+	} else {
+		fmt.Fprintf(os.Stderr, "\rExported %d entries", exported)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Number of the most recent matching entries sample values of fields are taken from
+const fieldSampleEntries = 100
+
+// Maximum number of distinct sample values shown per field and their maximum length
+const (
+	fieldSampleValues      = 3
+	fieldSampleValueLength = 30
+)
+
+// Prints fields defined in the mappings of the resolved indices with their type, whether they are analyzed and
+// sample values taken from the most recent entries matching the query. Field names can be used in message format
+// and field filters as they are.
+func (t *Tail) ListFields() {
+	mappings, err := t.backend.FieldMappings(t.indices)
+	if err != nil {
+		Error.Fatalln("Failed to fetch mappings of indices.", err)
+	}
+	if len(mappings) == 0 {
+		fmt.Fprintln(InfoOutput, paintInfoline("No fields found in indices "+strings.Join(t.indices, ", ")))
+		return
+	}
+	samples := t.sampleFieldValues(mappings)
+
+	paths := make([]string, 0, len(mappings))
+	pathWidth, typeWidth := len("field"), len("type")
+	for path, mapping := range mappings {
+		paths = append(paths, path)
+		if len(path) > pathWidth {
+			pathWidth = len(path)
+		}
+		if len(mapping.Type) > typeWidth {
+			typeWidth = len(mapping.Type)
+		}
+	}
+	sort.Strings(paths)
+
+	fmt.Println(paintInfoline(fmt.Sprintf("%s  %s  %-8s  %s", rightPad2Len("field", " ", pathWidth),
+		rightPad2Len("type", " ", typeWidth), "indexing", "samples")))
+	for _, path := range paths {
+		mapping := mappings[path]
+		fmt.Printf("%s  %s  %-8s  %s\n", rightPad2Len(path, " ", pathWidth), rightPad2Len(mapping.Type, " ", typeWidth),
+			fieldIndexing(mapping), strings.Join(samples[path], ", "))
+	}
+}
+
+// Describes how values of the field are indexed - analyzed text, keyword matched exactly or neither for other types
+func fieldIndexing(mapping *FieldMapping) string {
+	switch {
+	case mapping.Analyzed:
+		return "analyzed"
+	case mapping.Type == "keyword" || mapping.Type == "string":
+		return "keyword"
+	}
+	return "-"
+}
+
+// Collects distinct sample values of the fields from the most recent matching entries. Multi-fields (e.g.
+// host.keyword) are not present in the entries, so values of their parent field are used.
+func (t *Tail) sampleFieldValues(mappings map[string]*FieldMapping) map[string][]string {
+	samples := make(map[string][]string)
+	result, err := t.backend.Search(t.initialSearchRequest(fieldSampleEntries))
+	if err != nil {
+		Info.Printf("Failed to fetch sample values of fields: %s", err)
+		return samples
+	}
+	for _, hit := range result.Hits {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(hit.Source, &entry); err != nil {
+			continue
+		}
+		for path, mapping := range mappings {
+			if len(samples[path]) >= fieldSampleValues {
+				continue
+			}
+			sourcePath := path
+			if mapping.MultiField {
+				sourcePath = path[:strings.LastIndex(path, ".")]
+			}
+			if _, ok := lookupField(entry, sourcePath); !ok {
+				continue
+			}
+			value := fieldValue(entry, sourcePath)
+			if runes := []rune(value); len(runes) > fieldSampleValueLength {
+				value = string(runes[:fieldSampleValueLength]) + "..."
+			}
+			if !containsString(samples[path], value) {
+				samples[path] = append(samples[path], value)
+			}
+		}
+	}
+	return samples
+}
+
+// Warns about fields referenced in the message format which are not defined in any of the resolved indices, which
+// is usually a typo in the format
+func (t *Tail) WarnUnknownFormatFields() {
+	mappings, err := t.backend.FieldMappings(t.indices)
+	if err != nil {
+		Trace.Printf("Not checking fields of the message format, failed to fetch mappings: %s", err)
+		return
+	}
+	if len(mappings) == 0 {
+		return
+	}
+	for _, field := range formatFields(t.queryDefinition.Format) {
+		if !isMappedField(mappings, field) {
+			fmt.Fprintln(InfoOutput, paintInfoline(fmt.Sprintf("Warning: field %s of the message format does not exist in any of the indices %s. Use --fields to list available fields.",
+				field, strings.Join(t.indices, ", "))))
+		}
+	}
+}
+
+// Field is mapped if it is a field of the mapping or an object containing mapped fields
+func isMappedField(mappings map[string]*FieldMapping, field string) bool {
+	if _, ok := mappings[field]; ok {
+		return true
+	}
+	for path := range mappings {
+		if strings.HasPrefix(path, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
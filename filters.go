@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Regexp for parsing field filter expressions given using -F option
+var fieldFilterRegexp = regexp.MustCompile(`^(!?)([A-Za-z0-9@_.-]+?)(:\*|!=|>=|<=|!~|=|>|<|~)(.*)$`)
+
+// Parses field filter expression. Supported expressions are:
+//   field=value       field is equal to value (or one of comma separated values)
+//   field!=value      field is not equal to value (nor to any of comma separated values)
+//   field>=N          field is greater than or equal to N, similarly for >, <= and <
+//   field~regex       field matches the regular expression
+//   field!~regex      field does not match the regular expression
+//   field:*           field exists
+//   !field:*          field is missing
+func ParseFieldFilter(expression string) (Filter, error) {
+	match := fieldFilterRegexp.FindStringSubmatch(strings.TrimSpace(expression))
+	if match == nil {
+		return nil, fmt.Errorf("Invalid filter %s. Expected field=value, field!=value, field>=N, field<=N, field>N, "+
+			"field<N, field~regex, field!~regex, field:* or !field:*", expression)
+	}
+	negated, field, operator, value := match[1] == "!", match[2], match[3], match[4]
+
+	if negated && operator != ":*" {
+		return nil, fmt.Errorf("Invalid filter %s. Only field existence (!field:*) can be negated using !", expression)
+	}
+	if operator == ":*" {
+		if value != "" {
+			return nil, fmt.Errorf("Invalid filter %s. Expected field:* or !field:*", expression)
+		}
+		if negated {
+			return NotFilter{Filter: ExistsFilter{Field: field}}, nil
+		}
+		return ExistsFilter{Field: field}, nil
+	}
+	if value == "" {
+		return nil, fmt.Errorf("Invalid filter %s. Value is missing", expression)
+	}
+
+	switch operator {
+	case "=":
+		return TermsFilter{Field: field, Values: strings.Split(value, ",")}, nil
+	case "!=":
+		return NotFilter{Filter: TermsFilter{Field: field, Values: strings.Split(value, ",")}}, nil
+	case ">":
+		return RangeFilter{Field: field, Gt: rangeValue(value)}, nil
+	case ">=":
+		return RangeFilter{Field: field, Gte: rangeValue(value)}, nil
+	case "<":
+		return RangeFilter{Field: field, Lt: rangeValue(value)}, nil
+	case "<=":
+		return RangeFilter{Field: field, Lte: rangeValue(value)}, nil
+	case "~":
+		return RegexpFilter{Field: field, Regexp: value}, nil
+	case "!~":
+		return NotFilter{Filter: RegexpFilter{Field: field, Regexp: value}}, nil
+	}
+	return nil, fmt.Errorf("Invalid filter %s. Unknown operator %s", expression, operator)
+}
+
+// Numeric bounds are sent as numbers, anything else (e.g. dates) as strings
+func rangeValue(value string) interface{} {
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return number
+	}
+	return value
+}
+
+// Evaluates the filter against the entry on the client side, used where the backend does not evaluate the filter
+// (e.g. watch rules). Regular expressions match the whole value like in ElasticSearch, ranges compare numbers
+// numerically and anything else as strings. Fields holding arrays match if any of the elements matches.
+func filterMatches(filter Filter, entry map[string]interface{}) bool {
+	switch f := filter.(type) {
+	case TermsFilter:
+		return anyFieldValue(entry, f.Field, func(value string) bool {
+			for _, v := range f.Values {
+				if value == v {
+					return true
+				}
+			}
+			return false
+		})
+	case RangeFilter:
+		return anyFieldElement(entry, f.Field, func(value interface{}) bool {
+			return rangeBoundMatches(value, f.Gt, 1, false) && rangeBoundMatches(value, f.Gte, 1, true) &&
+				rangeBoundMatches(value, f.Lt, -1, false) && rangeBoundMatches(value, f.Lte, -1, true)
+		})
+	case ExistsFilter:
+		_, ok := lookupField(entry, f.Field)
+		return ok
+	case RegexpFilter:
+		re, err := regexp.Compile("^(?:" + f.Regexp + ")$")
+		if err != nil {
+			Trace.Printf("Invalid regexp %s: %s", f.Regexp, err)
+			return false
+		}
+		return anyFieldValue(entry, f.Field, re.MatchString)
+	case MatchPhraseFilter:
+		return anyFieldValue(entry, f.Field, func(value string) bool {
+			return strings.Contains(strings.ToLower(value), strings.ToLower(f.Phrase))
+		})
+	case NotFilter:
+		return !filterMatches(f.Filter, entry)
+	}
+	Trace.Printf("Unsupported filter %#v", filter)
+	return false
+}
+
+// Calls match for the value of the field, or for each element if the value is an array, until it returns true
+func anyFieldElement(entry map[string]interface{}, field string, match func(interface{}) bool) bool {
+	value, ok := lookupField(entry, field)
+	if !ok {
+		return false
+	}
+	values, isArray := value.([]interface{})
+	if !isArray {
+		return match(value)
+	}
+	for _, element := range values {
+		if element != nil && match(element) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compares the value with the bound, direction is 1 for lower bounds and -1 for upper bounds. Nil bound always matches.
+func rangeBoundMatches(value interface{}, bound interface{}, direction int, inclusive bool) bool {
+	if bound == nil {
+		return true
+	}
+	var comparison int
+	number, isNumber := value.(float64)
+	if s, ok := value.(string); ok && !isNumber {
+		number, isNumber = parseFloat(s)
+	}
+	boundNumber, boundIsNumber := bound.(float64)
+	valueTime, valueIsTime := parseTimestamp(value)
+	boundTime, boundIsTime := parseTimestamp(bound)
+	if isNumber && boundIsNumber {
+		switch {
+		case number < boundNumber:
+			comparison = -1
+		case number > boundNumber:
+			comparison = 1
+		}
+	} else if valueIsTime && boundIsTime {
+		//timestamps may differ in offset and precision, so they can't be compared as strings
+		switch {
+		case valueTime.Before(boundTime):
+			comparison = -1
+		case valueTime.After(boundTime):
+			comparison = 1
+		}
+	} else {
+		comparison = strings.Compare(fmt.Sprintf("%v", value), fmt.Sprintf("%v", bound))
+	}
+	return comparison == direction || (inclusive && comparison == 0)
+}
+
+func parseFloat(value string) (float64, bool) {
+	number, err := strconv.ParseFloat(value, 64)
+	return number, err == nil
+}
+
+// Parses RFC 3339 timestamp, other values are not timestamps
+func parseTimestamp(value interface{}) (time.Time, bool) {
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, s)
+	return parsed, err == nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestParseFieldFilter(t *testing.T) {
+	cases := []struct {
+		expression string
+		expected   Filter
+	}{
+		{"status=500", TermsFilter{Field: "status", Values: []string{"500"}}},
+		{" level=ERROR,WARN ", TermsFilter{Field: "level", Values: []string{"ERROR", "WARN"}}},
+		{"level!=INFO", NotFilter{Filter: TermsFilter{Field: "level", Values: []string{"INFO"}}}},
+		{"request.path=/api/users", TermsFilter{Field: "request.path", Values: []string{"/api/users"}}},
+		{"@timestamp>=2024-01-02", RangeFilter{Field: "@timestamp", Gte: "2024-01-02"}},
+		{"status>499", RangeFilter{Field: "status", Gt: 499.0}},
+		{"ms<=1.5", RangeFilter{Field: "ms", Lte: 1.5}},
+		{"ms<100", RangeFilter{Field: "ms", Lt: 100.0}},
+		{"host~web-.*", RegexpFilter{Field: "host", Regexp: "web-.*"}},
+		{"host!~web-.*", NotFilter{Filter: RegexpFilter{Field: "host", Regexp: "web-.*"}}},
+		{"user:*", ExistsFilter{Field: "user"}},
+		{"!user:*", NotFilter{Filter: ExistsFilter{Field: "user"}}},
+		{"url=http://host/?a=b", TermsFilter{Field: "url", Values: []string{"http://host/?a=b"}}},
+	}
+	for _, c := range cases {
+		filter, err := ParseFieldFilter(c.expression)
+		if err != nil {
+			t.Errorf("%s: %s", c.expression, err)
+			continue
+		}
+		if !reflect.DeepEqual(filter, c.expected) {
+			t.Errorf("%s: expected %#v, got %#v", c.expression, c.expected, filter)
+		}
+	}
+}
+
+func TestParseFieldFilterErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"status",
+		"=500",
+		"status=",
+		"status>=",
+		"!status=500",
+		"!host~web",
+		"user:*x",
+		"user:",
+		"the status=500",
+	} {
+		if filter, err := ParseFieldFilter(expression); err == nil {
+			t.Errorf("%s: expected error, got %#v", expression, filter)
+		}
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	cases := []struct {
+		filter  string
+		matches bool
+	}{
+		{"level=ERROR", true},
+		{"level=error", false},
+		{"level=WARN,ERROR", true},
+		{"level!=ERROR", false},
+		{"level!=INFO", true},
+		{"status=503", true},
+		{"request.path=/api/users", true},
+		{"missing!=x", true},
+		{"missing=x", false},
+		//numbers are compared numerically, also when stored as strings
+		{"status>=503", true},
+		{"status>503", false},
+		{"status<1000", true},
+		{"request.ms>99", true},
+		{"request.ms<=100", false},
+		{"missing<1", false},
+		{"host<web-3", true},
+		//timestamps are compared as times, they may differ in offset and precision
+		{"@timestamp>=2024-01-02T11:00:00.123+01:00", true},
+		{"@timestamp>2024-01-02T11:00:00.123+01:00", false},
+		{"@timestamp<2024-01-02T10:00:01Z", true},
+		//regular expressions match the whole value
+		{"host~web-[0-9]", true},
+		{"host~web", false},
+		{"host!~web", true},
+		{"status~5.*", true},
+		{"missing~.*", false},
+		{"host~[", false},
+		//existence, null values are missing
+		{"host:*", true},
+		{"request.path:*", true},
+		{"user:*", false},
+		{"!user:*", true},
+		{"!host:*", false},
+		//array fields match if any of the elements matches
+		{"tags=prod", true},
+		{"tags=eu-west", true},
+		{"tags=us", false},
+		{"tags!=prod", false},
+		{"tags~eu-.*", true},
+		{"codes>=400", true},
+		{"codes>404", false},
+		{"codes=200", true},
+		{"tags:*", true},
+	}
+	for _, c := range cases {
+		filter, err := ParseFieldFilter(c.filter)
+		if err != nil {
+			t.Errorf("%s: %s", c.filter, err)
+			continue
+		}
+		if matches := filterMatches(filter, testEntry()); matches != c.matches {
+			t.Errorf("%s: expected match %t, got %t", c.filter, c.matches, matches)
+		}
+	}
+}
+
+func TestMatchPhraseFilterMatches(t *testing.T) {
+	cases := []struct {
+		filter  MatchPhraseFilter
+		matches bool
+	}{
+		{MatchPhraseFilter{Field: "message", Phrase: "timeout to DB"}, true},
+		{MatchPhraseFilter{Field: "message", Phrase: "db timeout"}, false},
+		{MatchPhraseFilter{Field: "tags", Phrase: "west"}, true},
+		{MatchPhraseFilter{Field: "missing", Phrase: "x"}, false},
+	}
+	for _, c := range cases {
+		if matches := filterMatches(c.filter, testEntry()); matches != c.matches {
+			t.Errorf("%v: expected match %t, got %t", c.filter, c.matches, matches)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Operators of the query string syntax, they are not highlighted
+var queryOperators = map[string]bool{"AND": true, "OR": true, "NOT": true, "&&": true, "||": true, "TO": true}
+
+// Field prefix of a query string term, e.g. message: in message:timeout
+var queryFieldPrefixRegexp = regexp.MustCompile(`^[A-Za-z0-9@_.\-*]+:`)
+
+// Fuzziness, proximity and boost suffixes of a query string term, e.g. ~2 in timeout~2 or ^3 in "db error"^3
+var queryTermSuffixRegexp = regexp.MustCompile(`([~^][0-9.]*)+$`)
+
+// Kind of the highlighted match, determines its color
+const (
+	highlightSearch = iota
+	highlightWatch
+)
+
+// Highlights matches of search terms and watch rules in field values
+type highlighter struct {
+	search *regexp.Regexp
+	watch  []*regexp.Regexp
+}
+
+type highlightSpan struct {
+	start int
+	end   int
+	kind  int
+}
+
+// Creates highlighter for terms of the query string and watch rules. Nil regexps are ignored.
+func newHighlighter(queryString string, w *watcher) *highlighter {
+	h := &highlighter{search: searchTermsRegexp(queryTerms(queryString))}
+	if w != nil {
+		h.watch = w.highlightRegexps()
+	}
+	return h
+}
+
+// Splits query string into terms to be highlighted. Operators, field prefixes, negated terms, ranges and comparisons
+// are left out, quoted phrases are kept together with their quotes.
+func queryTerms(queryString string) []string {
+	terms := []string{}
+	negateNext := false
+	inRange := false
+	for _, token := range tokenizeQueryString(queryString) {
+		if inRange {
+			inRange = !strings.HasSuffix(token, "]") && !strings.HasSuffix(token, "}")
+			continue
+		}
+		if queryOperators[token] {
+			negateNext = token == "NOT"
+			continue
+		}
+		negated := negateNext
+		negateNext = false
+		if strings.HasPrefix(token, "-") || strings.HasPrefix(token, "!") {
+			negated = true
+			token = token[1:]
+		}
+		token = strings.TrimPrefix(token, "+")
+		token = queryFieldPrefixRegexp.ReplaceAllString(token, "")
+		if strings.HasPrefix(token, "[") || strings.HasPrefix(token, "{") {
+			inRange = !strings.HasSuffix(token, "]") && !strings.HasSuffix(token, "}")
+			continue
+		}
+		token = queryTermSuffixRegexp.ReplaceAllString(token, "")
+		if negated || token == "" || strings.HasPrefix(token, ">") || strings.HasPrefix(token, "<") ||
+			strings.Trim(token, "*?\"") == "" {
+			continue
+		}
+		terms = append(terms, token)
+	}
+	return terms
+}
+
+// Splits query string on whitespace and parentheses, quoted phrases (possibly with field prefix) are single tokens
+func tokenizeQueryString(queryString string) []string {
+	tokens := []string{}
+	var current strings.Builder
+	inQuotes := false
+	escaped := false
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	for _, r := range queryString {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			current.WriteRune(r)
+			inQuotes = !inQuotes
+		case inQuotes:
+			current.WriteRune(r)
+		case unicode.IsSpace(r) || r == '(' || r == ')':
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// Builds case-insensitive regexp matching any of the terms as whole tokens. Wildcards * and ? match any word
+// characters, words of quoted phrases may be separated by any non-word characters. Returns nil if there are no terms.
+func searchTermsRegexp(terms []string) *regexp.Regexp {
+	alternatives := []string{}
+	for _, term := range terms {
+		var pattern string
+		if strings.HasPrefix(term, "\"") {
+			words := strings.Fields(strings.Trim(term, "\""))
+			if len(words) == 0 {
+				continue
+			}
+			for i, word := range words {
+				words[i] = regexp.QuoteMeta(word)
+			}
+			pattern = strings.Join(words, `\W+`)
+		} else {
+			pattern = regexp.QuoteMeta(term)
+			pattern = strings.Replace(pattern, `\*`, `\w*`, -1)
+			pattern = strings.Replace(pattern, `\?`, `\w`, -1)
+		}
+		alternatives = append(alternatives, tokenBoundaries(strings.Trim(term, "\""), pattern, !strings.HasPrefix(term, "\"")))
+	}
+	if len(alternatives) == 0 {
+		return nil
+	}
+	re, err := regexp.Compile("(?i)" + strings.Join(alternatives, "|"))
+	if err != nil {
+		Trace.Printf("Failed to build highlighting regexp for terms %s: %s", terms, err)
+		return nil
+	}
+	return re
+}
+
+// Terms match whole tokens, so word boundaries are added where the term starts or ends with a word character or with
+// a wildcard (wildcards match word characters, phrases have no wildcards)
+func tokenBoundaries(term string, pattern string, wildcards bool) string {
+	if term == "" {
+		return pattern
+	}
+	bounded := func(r rune) bool {
+		return isWordRune(r) || wildcards && (r == '*' || r == '?')
+	}
+	runes := []rune(term)
+	if bounded(runes[0]) {
+		pattern = `\b` + pattern
+	}
+	if last := runes[len(runes)-1]; bounded(last) {
+		pattern = pattern + `\b`
+	}
+	return pattern
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Highlights matches of search terms, watch rules and parts of the value the backend reported as matching the query
+// (may be nil). Of overlapping matches the one starting first is highlighted, watch matches win ties.
+func (h *highlighter) highlight(value string, matchedParts []string) string {
+	return h.highlightPainted(value, matchedParts, nil)
+}
+
+// Highlights the value like highlight, parts of the value that are not highlighted are painted using paint (if not nil)
+func (h *highlighter) highlightPainted(value string, matchedParts []string, paint func(string) string) string {
+	plain := func(part string) string {
+		if paint == nil || part == "" {
+			return part
+		}
+		return paint(part)
+	}
+	spans := []highlightSpan{}
+	for _, re := range h.watch {
+		spans = appendSpans(spans, re, value, highlightWatch)
+	}
+	spans = appendSpans(spans, h.search, value, highlightSearch)
+	if len(matchedParts) > 0 {
+		quoted := make([]string, len(matchedParts))
+		for i, part := range matchedParts {
+			quoted[i] = regexp.QuoteMeta(part)
+		}
+		spans = appendSpans(spans, regexp.MustCompile("(?i)"+strings.Join(quoted, "|")), value, highlightSearch)
+	}
+	if len(spans) == 0 {
+		return plain(value)
+	}
+
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var result strings.Builder
+	position := 0
+	for _, span := range spans {
+		if span.start < position {
+			continue //overlaps previous match
+		}
+		result.WriteString(plain(value[position:span.start]))
+		if span.kind == highlightWatch {
+			result.WriteString(highlightWatchContent(value[span.start:span.end]))
+		} else {
+			result.WriteString(highlightContent(value[span.start:span.end]))
+		}
+		position = span.end
+	}
+	result.WriteString(plain(value[position:]))
+	return result.String()
+}
+
+func appendSpans(spans []highlightSpan, re *regexp.Regexp, value string, kind int) []highlightSpan {
+	if re == nil {
+		return spans
+	}
+	for _, match := range re.FindAllStringIndex(value, -1) {
+		if match[1] > match[0] {
+			spans = append(spans, highlightSpan{start: match[0], end: match[1], kind: kind})
+		}
+	}
+	return spans
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Intervals the automatic interval of the histogram is chosen from
+var histogramIntervals = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 7 * 24 * time.Hour,
+}
+
+// Number of buckets aimed for when the interval is chosen automatically for the bar chart
+const histogramBarChartBuckets = 40
+
+// Maximum number of buckets of the histogram. Shorter intervals given using --interval are widened, as the chart would
+// not be readable and clusters limit the number of buckets (search.max_buckets).
+const histogramMaxBuckets = 500
+
+// Maximum number of sources the histogram is split by
+const histogramSplitSources = 10
+
+const defaultTerminalWidth = 80
+
+var sparklineRunes = []rune("▁▂▃▄▅▆▇█")
+
+// Eighths of a block used to draw the fractional end of a bar
+var barRunes = []rune(" ▏▎▍▌▋▊▉")
+
+type histogramBucket struct {
+	start time.Time
+	count int64
+}
+
+// Prints number of entries matching the query per time interval as a bar chart, sparkline or one sparkline per
+// source if split by source. Interval is chosen automatically unless given.
+func (t *Tail) Histogram() {
+	start, end, err := t.queryTimeWindow()
+	if err != nil {
+		Error.Fatalln(err)
+	}
+	width := terminalWidth()
+	sparkline := t.queryDefinition.Sparkline || t.queryDefinition.HistogramSplit
+
+	var interval time.Duration
+	if t.queryDefinition.HistogramInterval != "" {
+		interval, err = parseDuration(t.queryDefinition.HistogramInterval)
+		if err != nil {
+			Error.Fatalln(err)
+		}
+		if interval < time.Millisecond {
+			Error.Fatalf("Interval %s of the histogram is too short\n", t.queryDefinition.HistogramInterval)
+		}
+		if end.Sub(start)/interval >= histogramMaxBuckets {
+			widened := histogramInterval(end.Sub(start), histogramMaxBuckets)
+			fmt.Fprintln(InfoOutput, paintInfoline(fmt.Sprintf("Interval %s would give more than %d buckets, using %s instead",
+				interval, histogramMaxBuckets, widened)))
+			interval = widened
+		}
+	} else {
+		buckets := histogramBarChartBuckets
+		if sparkline {
+			buckets = width - 40
+		}
+		interval = histogramInterval(end.Sub(start), buckets)
+	}
+	Info.Printf("Using histogram interval %s", interval)
+
+	histogram := DateHistogramAggregation{Field: t.queryDefinition.TimestampField, Interval: interval, Min: start, Max: end}
+	request := &SearchRequest{Indices: t.indices, Query: t.buildSearchQuery()}
+	if t.queryDefinition.HistogramSplit {
+		request.Aggregations = map[string]Aggregation{
+			"source": TermsAggregation{Field: "source", Size: histogramSplitSources,
+				SubAggregations: map[string]Aggregation{"histogram": histogram}},
+		}
+	} else {
+		request.Aggregations = map[string]Aggregation{"histogram": histogram}
+	}
+	result, err := t.backend.Aggregate(request)
+	if err != nil {
+		Error.Fatalln("Error in executing search query.", err)
+	}
+
+	fmt.Println(paintInfoline(fmt.Sprintf("Entries per %s from %s to %s", interval,
+		start.In(localTz).Format("2006-01-02 15:04:05"), end.In(localTz).Format("2006-01-02 15:04:05"))))
+	if !t.queryDefinition.HistogramSplit {
+		buckets := histogramBuckets(result, "histogram")
+		if sparkline {
+			fmt.Printf("%s %d\n", renderSparkline(buckets), histogramTotal(buckets))
+		} else {
+			printBarChart(buckets, histogramLabelLayout(interval, end.Sub(start)), width)
+		}
+		return
+	}
+
+	sources, _ := result.Buckets("source")
+	if len(sources) == 0 {
+		fmt.Fprintln(InfoOutput, paintInfoline("No entries found"))
+		return
+	}
+	nameWidth := 0
+	for _, source := range sources {
+		if len(source.KeyString()) > nameWidth {
+			nameWidth = len(source.KeyString())
+		}
+	}
+	for _, source := range sources {
+		buckets := histogramBuckets(source.Aggregations, "histogram")
+		fmt.Printf("%s %s %d\n", paintSource(rightPad2Len(source.KeyString(), " ", nameWidth)), renderSparkline(buckets),
+			histogramTotal(buckets))
+	}
+}
+
+// Returns the time window of the query. Start of the window is required, end defaults to now.
+func (t *Tail) queryTimeWindow() (time.Time, time.Time, error) {
+	if t.queryDefinition.AfterDateTime == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("Time window is required, please specify it using -d or -a option")
+	}
+	start := parseUTCTimestamp(t.queryDefinition.AfterDateTimeInUTC(), t.queryDefinition.AfterDateTime)
+	end := time.Now().UTC()
+	if t.queryDefinition.BeforeDateTime != "" {
+		end = parseUTCTimestamp(t.queryDefinition.BeforeDateTimeInUTC(), t.queryDefinition.BeforeDateTime)
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("Time window is empty (%s - %s)", start, end)
+	}
+	return start, end, nil
+}
+
+// Picks the smallest interval splitting the window into at most given number of buckets
+func histogramInterval(window time.Duration, buckets int) time.Duration {
+	if buckets < 1 {
+		buckets = 1
+	}
+	for _, interval := range histogramIntervals {
+		if window/interval < time.Duration(buckets) {
+			return interval
+		}
+	}
+	return histogramIntervals[len(histogramIntervals)-1]
+}
+
+// Labels of the bar chart show only as much of the time as needed for given interval and window
+func histogramLabelLayout(interval time.Duration, window time.Duration) string {
+	switch {
+	case interval >= 24*time.Hour:
+		return "2006-01-02"
+	case window > 24*time.Hour:
+		return "01-02 15:04"
+	case interval < time.Minute:
+		return "15:04:05"
+	}
+	return "15:04"
+}
+
+func histogramBuckets(aggregations AggregationResults, name string) []*histogramBucket {
+	buckets, _ := aggregations.Buckets(name)
+	result := make([]*histogramBucket, 0, len(buckets))
+	for _, bucket := range buckets {
+		millis, ok := bucket.Key.(float64)
+		if !ok {
+			Trace.Printf("Unexpected key of histogram bucket: %v", bucket.Key)
+			continue
+		}
+		result = append(result, &histogramBucket{start: millisToTime(millis), count: bucket.DocCount})
+	}
+	return result
+}
+
+func histogramTotal(buckets []*histogramBucket) int64 {
+	var total int64
+	for _, bucket := range buckets {
+		total += bucket.count
+	}
+	return total
+}
+
+func histogramMax(buckets []*histogramBucket) int64 {
+	var max int64
+	for _, bucket := range buckets {
+		if bucket.count > max {
+			max = bucket.count
+		}
+	}
+	return max
+}
+
+// Prints one bar per bucket scaled to the width of the terminal
+func printBarChart(buckets []*histogramBucket, labelLayout string, width int) {
+	max := histogramMax(buckets)
+	countWidth := len(fmt.Sprintf("%d", max))
+	barWidth := width - len(labelLayout) - countWidth - 3
+	if barWidth < 10 {
+		barWidth = 10
+	}
+	for _, bucket := range buckets {
+		bar := ""
+		if max > 0 {
+			bar = renderBar(float64(bucket.count) / float64(max) * float64(barWidth))
+		}
+		fmt.Printf("%s %*d %s\n", color.GreenString(bucket.start.In(localTz).Format(labelLayout)), countWidth, bucket.count, bar)
+	}
+	fmt.Println(paintInfoline(fmt.Sprintf("Total %d entries", histogramTotal(buckets))))
+}
+
+// Renders bar of given length in characters, fractional part is drawn using eighths of a block
+func renderBar(length float64) string {
+	eighths := int(math.Round(length * 8))
+	bar := strings.Repeat("█", eighths/8)
+	if eighths%8 > 0 {
+		bar += string(barRunes[eighths%8])
+	}
+	return bar
+}
+
+// Renders one character per bucket, empty buckets are blank so that gaps stand out
+func renderSparkline(buckets []*histogramBucket) string {
+	max := histogramMax(buckets)
+	line := make([]rune, len(buckets))
+	for i, bucket := range buckets {
+		if bucket.count == 0 {
+			line[i] = ' '
+			continue
+		}
+		level := int(float64(bucket.count) / float64(max) * float64(len(sparklineRunes)-1))
+		line[i] = sparklineRunes[level]
+	}
+	return string(line)
+}
+
+// Returns width of the terminal the output is written to
+func terminalWidth() int {
+	width, _, err := terminal.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 {
+		return defaultTerminalWidth
+	}
+	return width
+}
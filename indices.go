@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// Index naming scheme tells which period of time is covered by an index. Logstash creates daily indices by default
+// (logstash-YYYY.MM.DD), but clusters may just as well use hourly, weekly or monthly indices, or rollover aliases and
+// data streams whose names do not contain a date at all.
+//
+type IndexNamingScheme interface {
+	// Returns the time span [start, end) covered by the index. If the scheme cannot tell, ok is false.
+	IndexTimeSpan(index string) (start time.Time, end time.Time, ok bool)
+}
+
+// Names of supported index naming schemes. Custom date layouts can be given as "layout:<go time layout>", for example
+// "layout:2006-01-02".
+const (
+	IndexNamingDaily   = "daily"
+	IndexNamingHourly  = "hourly"
+	IndexNamingWeekly  = "weekly"
+	IndexNamingMonthly = "monthly"
+	IndexNamingCluster = "cluster"
+	indexNamingLayout  = "layout:"
+)
+
+// Creates index naming scheme with given name. Cluster scheme asks the cluster for the min/max timestamp of each of
+// the given indices matching the index pattern.
+func NewIndexNamingScheme(name string, backend LogBackend, indices []string, indexPattern string,
+	timestampField string) (IndexNamingScheme, error) {
+	switch {
+	case name == "" || name == IndexNamingDaily:
+		return newLayoutNamingScheme("2006.01.02"), nil
+	case name == IndexNamingHourly:
+		return newLayoutNamingScheme("2006.01.02.15"), nil
+	case name == IndexNamingMonthly:
+		return newLayoutNamingScheme("2006.01"), nil
+	case name == IndexNamingWeekly:
+		return new(weeklyNamingScheme), nil
+	case strings.HasPrefix(name, indexNamingLayout) && len(name) > len(indexNamingLayout):
+		return newLayoutNamingScheme(name[len(indexNamingLayout):]), nil
+	case name == IndexNamingCluster:
+		return newClusterNamingScheme(backend, matchingIndices(indices, indexPattern), timestampField)
+	}
+	return nil, fmt.Errorf("Unknown index naming scheme %s. Supported schemes: %s, %s, %s, %s, %s and layout:<layout>",
+		name, IndexNamingDaily, IndexNamingHourly, IndexNamingWeekly, IndexNamingMonthly, IndexNamingCluster)
+}
+
+// -- Date layout scheme --
+
+// Naming scheme for indices whose names end with a date formatted using a Go time layout. The period covered by an
+// index is derived from the finest element of the layout (hour, day, month or year).
+type layoutNamingScheme struct {
+	layout    string
+	dateRegex *regexp.Regexp
+	period    func(time.Time) time.Time
+}
+
+var layoutElements = []struct {
+	element string
+	regex   string
+}{
+	{"2006", `\d{4}`},
+	{"01", `\d{2}`},
+	{"02", `\d{2}`},
+	{"15", `\d{2}`},
+}
+
+func newLayoutNamingScheme(layout string) *layoutNamingScheme {
+	regex := ""
+	for rest := layout; rest != ""; {
+		matched := false
+		for _, e := range layoutElements {
+			if strings.HasPrefix(rest, e.element) {
+				regex += e.regex
+				rest = rest[len(e.element):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			regex += regexp.QuoteMeta(rest[:1])
+			rest = rest[1:]
+		}
+	}
+
+	scheme := &layoutNamingScheme{layout: layout, dateRegex: regexp.MustCompile(regex)}
+	switch {
+	case strings.Contains(layout, "15"):
+		scheme.period = func(t time.Time) time.Time { return t.Add(time.Hour) }
+	case strings.Contains(layout, "02"):
+		scheme.period = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	case strings.Contains(layout, "01"):
+		scheme.period = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	default:
+		scheme.period = func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }
+	}
+	return scheme
+}
+
+func (s *layoutNamingScheme) IndexTimeSpan(index string) (time.Time, time.Time, bool) {
+	matches := s.dateRegex.FindAllString(index, -1)
+	if len(matches) == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	start, err := time.Parse(s.layout, matches[len(matches)-1])
+	if err != nil {
+		Trace.Printf("Failed parsing date of index %s using layout %s: %s", index, s.layout, err)
+		return time.Time{}, time.Time{}, false
+	}
+	return start, s.period(start), true
+}
+
+// -- Weekly scheme --
+
+var weeklyIndexRegexp = regexp.MustCompile(`(\d{4})[.-]?W(\d{1,2})`)
+
+// Naming scheme for indices named after ISO weeks, e.g. app-2024.W12
+type weeklyNamingScheme struct{}
+
+func (s *weeklyNamingScheme) IndexTimeSpan(index string) (time.Time, time.Time, bool) {
+	matches := weeklyIndexRegexp.FindAllStringSubmatch(index, -1)
+	if len(matches) == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	match := matches[len(matches)-1]
+	year, _ := strconv.Atoi(match[1])
+	week, _ := strconv.Atoi(match[2])
+	if week < 1 || week > 53 {
+		return time.Time{}, time.Time{}, false
+	}
+	//ISO week 1 is the week containing January 4th, weeks start on Monday
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	daysSinceMonday := (int(jan4.Weekday()) + 6) % 7
+	start := jan4.AddDate(0, 0, -daysSinceMonday+(week-1)*7)
+	return start, start.AddDate(0, 0, 7), true
+}
+
+// -- Cluster scheme --
+
+type indexTimeSpan struct {
+	start time.Time
+	end   time.Time
+}
+
+// Indices whose last entry is older than this are not expected to get new entries, so their time spans are not
+// resolved again
+const clusterIndexQuietPeriod = 15 * time.Minute
+
+// Naming scheme that does not rely on index names at all. The cluster is asked for the min and max timestamp of the
+// entries in each index, which makes it work with rollover aliases and data streams.
+type clusterNamingScheme struct {
+	spans map[string]indexTimeSpan
+}
+
+func newClusterNamingScheme(backend LogBackend, indices []string, timestampField string) (*clusterNamingScheme, error) {
+	scheme := &clusterNamingScheme{spans: make(map[string]indexTimeSpan)}
+	return scheme, scheme.refresh(backend, indices, timestampField, time.Now())
+}
+
+// Resolves time spans of indices that are new or may still be written to - the ones with the most recent entries,
+// entries within the quiet period or no entries yet. Spans of other indices are kept from the previous resolution, so
+// that following new indices while tailing doesn't aggregate over all the indices again.
+func (s *clusterNamingScheme) refresh(backend LogBackend, indices []string, timestampField string, now time.Time) error {
+	var newest time.Time
+	for _, span := range s.spans {
+		if span.end.After(newest) {
+			newest = span.end
+		}
+	}
+	existing := make(map[string]bool, len(indices))
+	resolved := []string{}
+	for _, index := range indices {
+		existing[index] = true
+		span, ok := s.spans[index]
+		if !ok || !span.end.Before(newest) || span.end.After(now.Add(-clusterIndexQuietPeriod)) {
+			resolved = append(resolved, index)
+		}
+	}
+	for index := range s.spans {
+		if !existing[index] {
+			delete(s.spans, index) //index was deleted
+		}
+	}
+	if len(resolved) == 0 {
+		return nil
+	}
+
+	aggregations, err := backend.Aggregate(&SearchRequest{
+		Indices: resolved,
+		Aggregations: map[string]Aggregation{
+			"indices": TermsAggregation{
+				Field: "_index",
+				Size:  len(resolved),
+				SubAggregations: map[string]Aggregation{
+					"min": MinAggregation{Field: timestampField},
+					"max": MaxAggregation{Field: timestampField},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, index := range resolved {
+		delete(s.spans, index)
+	}
+	buckets, _ := aggregations.Buckets("indices")
+	for _, bucket := range buckets {
+		min, minOk := bucket.Aggregations.Value("min")
+		max, maxOk := bucket.Aggregations.Value("max")
+		if !minOk || !maxOk || min == nil || max == nil {
+			continue
+		}
+		s.spans[bucket.KeyString()] = indexTimeSpan{
+			start: millisToTime(*min),
+			end:   millisToTime(*max).Add(time.Millisecond),
+		}
+	}
+	Trace.Printf("Resolved time spans of %d of %d indices from the cluster", len(resolved), len(indices))
+	return nil
+}
+
+func (s *clusterNamingScheme) IndexTimeSpan(index string) (time.Time, time.Time, bool) {
+	span, ok := s.spans[index]
+	return span.start, span.end, ok
+}
+
+func millisToTime(millis float64) time.Time {
+	return time.Unix(0, int64(millis)*int64(time.Millisecond)).UTC()
+}
+
+// -- Index selection --
+
+func matchingIndices(indices []string, indexPattern string) []string {
+	result := make([]string, 0, len(indices))
+	for _, idx := range indices {
+		matched, _ := regexp.MatchString(indexPattern, idx)
+		if matched {
+			result = append(result, idx)
+		}
+	}
+	return result
+}
+
+// Finds indices matching the pattern that overlap with given time range. Indices whose time span cannot be determined
+// by the naming scheme are always included, as they may contain entries from the range.
+func findIndicesForDateRange(indices []string, indexPattern string, scheme IndexNamingScheme, start time.Time,
+	end time.Time) []string {
+	result := make([]string, 0, len(indices))
+	for _, idx := range matchingIndices(indices, indexPattern) {
+		idxStart, idxEnd, ok := scheme.IndexTimeSpan(idx)
+		if !ok {
+			Info.Printf("Could not determine time span of index %s, including it in the search", idx)
+			result = append(result, idx)
+		} else if !idxStart.After(end) && idxEnd.After(start) {
+			result = append(result, idx)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Finds the most recent index matching the pattern. If the naming scheme cannot tell time span of any index, indices
+// are compared by name.
+func findLastIndex(indices []string, indexPattern string, scheme IndexNamingScheme) string {
+	var lastIdx string
+	var lastStart time.Time
+	lastKnown := false
+	for _, idx := range matchingIndices(indices, indexPattern) {
+		idxStart, _, ok := scheme.IndexTimeSpan(idx)
+		switch {
+		case ok && (!lastKnown || idxStart.After(lastStart) || (idxStart.Equal(lastStart) && idx > lastIdx)):
+			lastIdx, lastStart, lastKnown = idx, idxStart, true
+		case !ok && !lastKnown && idx > lastIdx:
+			lastIdx = idx
+		}
+	}
+	return lastIdx
+}
+
+// Returns start of the time span covered by the index, or zero time if the scheme cannot tell.
+func indexStartTime(index string, scheme IndexNamingScheme) time.Time {
+	start, _, _ := scheme.IndexTimeSpan(index)
+	return start
+}
@@ -4,13 +4,171 @@ import "C"
 
 import (
 	"bufio"
-	"os"
 	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/ssh/terminal"
 )
 
-func shouldFetchMoreEntries() bool {
-	fmt.Print("Fetch more logs or quit (m/q)? ")
-	reader := bufio.NewReader(os.Stdin)
-	input, _ := reader.ReadByte()
-	return string(input) == "m"
-}
\ No newline at end of file
+//
+// Keys read from the keyboard. Printable characters are keys on their own, other keys are negative.
+//
+type key rune
+
+const (
+	keyUnknown key = -(iota + 1)
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEnter
+	keyEscape
+	keyBackspace
+	keyInterrupt
+)
+
+// Escape sequences sent by terminals for the special keys (after ESC)
+var escapeSequences = map[string]key{
+	"[A": keyUp, "[B": keyDown, "[C": keyRight, "[D": keyLeft,
+	"OA": keyUp, "OB": keyDown, "OC": keyRight, "OD": keyLeft,
+	"[5~": keyPageUp, "[6~": keyPageDown,
+	"[H": keyHome, "[F": keyEnd, "OH": keyHome, "OF": keyEnd,
+	"[1~": keyHome, "[4~": keyEnd, "[7~": keyHome, "[8~": keyEnd,
+}
+
+var keyboard = bufio.NewReader(os.Stdin)
+
+// Returns true if standard input is a terminal, keys can be then read one by one without waiting for Enter
+func isInteractive() bool {
+	return terminal.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// Reads single key press. The terminal is switched to raw mode just for reading the key, so that the output is not
+// affected. When standard input is not a terminal, characters are read as they come.
+func readSingleKey() (key, error) {
+	if isInteractive() {
+		state, err := terminal.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			return keyUnknown, err
+		}
+		defer terminal.Restore(int(os.Stdin.Fd()), state)
+	}
+	return readKey()
+}
+
+// Reads and decodes next key. Terminal is expected to be in raw mode already.
+func readKey() (key, error) {
+	r, _, err := keyboard.ReadRune()
+	if err != nil {
+		return keyUnknown, err
+	}
+	switch r {
+	case '\r', '\n':
+		return keyEnter, nil
+	case 127, '\b':
+		return keyBackspace, nil
+	case 3, 4: //Ctrl+C and Ctrl+D
+		return keyInterrupt, nil
+	case 27:
+		return readEscapeSequence(), nil
+	}
+	if !unicode.IsPrint(r) {
+		return keyUnknown, nil
+	}
+	return key(r), nil
+}
+
+// Sequences of special keys arrive at once, ESC with nothing buffered behind it is the Escape key itself
+func readEscapeSequence() key {
+	sequence := ""
+	for keyboard.Buffered() > 0 {
+		b, _ := keyboard.ReadByte()
+		sequence += string(b)
+		if k, ok := escapeSequences[sequence]; ok {
+			return k
+		}
+		if len(sequence) > 1 && (b == '~' || b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z') {
+			return keyUnknown //end of a sequence we don't know
+		}
+	}
+	if sequence == "" {
+		return keyEscape
+	}
+	return keyUnknown
+}
+
+//
+// Single line text input, e.g. query typed in the query bar
+//
+type lineEditor struct {
+	text []rune
+}
+
+func newLineEditor(initial string) *lineEditor {
+	return &lineEditor{text: []rune(initial)}
+}
+
+func (e *lineEditor) String() string {
+	return string(e.text)
+}
+
+// Applies the key to the line. Returns true when editing is finished, either confirmed with Enter or cancelled with
+// Escape (or Ctrl+C).
+func (e *lineEditor) edit(k key) (finished bool, cancelled bool) {
+	switch {
+	case k == keyEnter:
+		return true, false
+	case k == keyEscape || k == keyInterrupt:
+		return true, true
+	case k == keyBackspace:
+		if len(e.text) > 0 {
+			e.text = e.text[:len(e.text)-1]
+		}
+	case k > 0:
+		e.text = append(e.text, rune(k))
+	}
+	return false, false
+}
+
+// Prompts the user for a line of text. Returns false if the input was cancelled.
+func readLine(prompt string) (string, bool) {
+	fmt.Fprint(InfoOutput, prompt)
+	if !isInteractive() {
+		line, err := keyboard.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", false
+		}
+		return strings.TrimRight(line, "\r\n"), true
+	}
+
+	state, err := terminal.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return "", false
+	}
+	defer terminal.Restore(int(os.Stdin.Fd()), state)
+	editor := newLineEditor("")
+	for {
+		k, err := readKey()
+		if err != nil {
+			return "", false
+		}
+		length := len(editor.text)
+		finished, cancelled := editor.edit(k)
+		if finished {
+			fmt.Fprint(InfoOutput, "\r\n")
+			return editor.String(), !cancelled
+		}
+		if len(editor.text) > length {
+			fmt.Fprint(InfoOutput, string(rune(k)))
+		} else if len(editor.text) < length {
+			fmt.Fprint(InfoOutput, "\b \b")
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protocols of listen addresses, tcp is used when the address has no scheme (--listen :5000)
+const (
+	listenTCP  = "tcp"
+	listenUDP  = "udp"
+	listenHTTP = "http"
+)
+
+// Number of received events waiting to be processed before the listeners block
+const listenQueueSize = 1000
+
+// Largest UDP datagram accepted
+const listenMaxDatagram = 65536
+
+// How often alert rules are evaluated while listening, also when no events arrive
+const listenAlertsInterval = time.Second
+
+// Line received by a listener, index is the address it was received on
+type listenedEvent struct {
+	index string
+	line  []byte
+}
+
+// Prints events pushed by Logstash (tcp or udp output with json_lines codec, or http output) as they arrive. Events
+// are filtered on the client side by the keywords, source, request id and field filters of the query, time filters
+// don't apply. Runs until the process is interrupted.
+func (t *Tail) Listen(addresses []string) {
+	t.queryDefinition.Duration = ""
+	t.queryDefinition.AfterDateTime = ""
+	t.queryDefinition.BeforeDateTime = ""
+	if t.watcher != nil {
+		t.watcher.armed = true //there's no history, every event is new
+	}
+	query := t.buildSearchQuery()
+	queryString, err := parseQueryString(query.QueryString)
+	if err != nil {
+		Error.Fatalln(err)
+	}
+
+	events := make(chan *listenedEvent, listenQueueSize)
+	for _, address := range addresses {
+		if err := startListener(address, events); err != nil {
+			Error.Fatalf("Failed to listen on %s: %s\n", address, err)
+		}
+	}
+	fmt.Fprintln(InfoOutput, paintInfoline(fmt.Sprintf("Listening on %s... press Ctrl+C to quit", strings.Join(addresses, ", "))))
+
+	//events are processed one by one here, so that output, watch and alerts are not touched concurrently
+	ticker := time.NewTicker(listenAlertsInterval)
+	defer ticker.Stop()
+	var received int64
+	for {
+		select {
+		case event := <-events:
+			received++
+			var entry map[string]interface{}
+			if err := json.Unmarshal(event.line, &entry); err != nil {
+				Trace.Printf("Skipping event that is not a JSON object received on %s: %s", event.index, event.line)
+				continue
+			}
+			if query.matchesEntry(queryString, entry) {
+				t.processHit(&SearchHit{Index: event.index, Id: strconv.FormatInt(received, 10), Source: event.line})
+			}
+		case now := <-ticker.C:
+			if t.alerts != nil {
+				t.alerts.evaluate(now)
+			}
+		}
+	}
+}
+
+// Starts listening on the address ([tcp|udp|http]://[host]:port) in the background
+func startListener(address string, events chan<- *listenedEvent) error {
+	protocol, hostPort := listenTCP, address
+	if i := strings.Index(address, "://"); i >= 0 {
+		protocol, hostPort = address[:i], address[i+3:]
+	}
+	switch protocol {
+	case listenTCP:
+		listener, err := net.Listen("tcp", hostPort)
+		if err != nil {
+			return err
+		}
+		go acceptConnections(listener, address, events)
+	case listenUDP:
+		conn, err := net.ListenPacket("udp", hostPort)
+		if err != nil {
+			return err
+		}
+		go receiveDatagrams(conn, address, events)
+	case listenHTTP:
+		listener, err := net.Listen("tcp", hostPort)
+		if err != nil {
+			return err
+		}
+		go http.Serve(listener, &listenHandler{index: address, events: events})
+	default:
+		return fmt.Errorf("Unknown protocol %s, supported protocols are %s, %s and %s", protocol, listenTCP, listenUDP, listenHTTP)
+	}
+	Info.Printf("Listening on %s", address)
+	return nil
+}
+
+func acceptConnections(listener net.Listener, index string, events chan<- *listenedEvent) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			Error.Printf("Failed to accept connection on %s: %s\n", index, err)
+			return
+		}
+		Info.Printf("Connection from %s on %s", conn.RemoteAddr(), index)
+		go receiveLines(conn, index, events)
+	}
+}
+
+// Each line of the connection is an event (json_lines codec)
+func receiveLines(conn net.Conn, index string, events chan<- *listenedEvent) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		sendLines(line, index, events)
+		if err != nil {
+			if err != io.EOF {
+				Info.Printf("Connection from %s on %s failed: %s", conn.RemoteAddr(), index, err)
+			}
+			return
+		}
+	}
+}
+
+// Each datagram holds one or more lines
+func receiveDatagrams(conn net.PacketConn, index string, events chan<- *listenedEvent) {
+	buffer := make([]byte, listenMaxDatagram)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			Error.Printf("Failed to receive datagram on %s: %s\n", index, err)
+			return
+		}
+		sendLines(buffer[:n], index, events)
+	}
+}
+
+func sendLines(data []byte, index string, events chan<- *listenedEvent) {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			events <- &listenedEvent{index: index, line: append([]byte{}, line...)}
+		}
+	}
+}
+
+//
+// Receives events POSTed by the http output of Logstash. Body is a single event (json format), an array of events
+// (json_batch format) or events separated by newlines.
+//
+type listenHandler struct {
+	index  string
+	events chan<- *listenedEvent
+}
+
+func (h *listenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "Events are accepted using POST", http.StatusMethodNotAllowed)
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	lines := [][]byte{}
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var batch []json.RawMessage
+		if json.Unmarshal(value, &batch) != nil {
+			batch = []json.RawMessage{value}
+		}
+		for _, event := range batch {
+			var line bytes.Buffer
+			if json.Compact(&line, event) == nil {
+				lines = append(lines, line.Bytes())
+			}
+		}
+	}
+	for _, line := range lines {
+		h.events <- &listenedEvent{index: h.index, line: line}
+	}
+	w.WriteHeader(http.StatusOK)
+}
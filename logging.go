@@ -3,14 +3,49 @@ package main
 import (
 	"log"
 	"io"
+	"os"
+	"fmt"
+)
+
+// Exit codes of the process, meant for scripts (e.g. if logstasher-cli --count ...; then)
+const (
+	exitHits   = 0 //entries matching the query were found
+	exitNoHits = 1 //no entries match the query
+	exitError  = 2 //the query could not be executed
 )
 
 var (
 	Trace   *log.Logger
 	Info    *log.Logger
-	Error   *log.Logger
+	Error   *errorLogger
 )
 
+// Informational lines for the user (e.g. active profile). They are written to stderr when the output is meant to be
+// processed by other tools.
+var InfoOutput io.Writer = os.Stdout
+
+//
+// Logger of errors. Fatal errors exit with exitError rather than 1, which means no hits.
+//
+type errorLogger struct {
+	*log.Logger
+}
+
+func (l *errorLogger) Fatal(v ...interface{}) {
+	l.Output(2, fmt.Sprint(v...))
+	os.Exit(exitError)
+}
+
+func (l *errorLogger) Fatalf(format string, v ...interface{}) {
+	l.Output(2, fmt.Sprintf(format, v...))
+	os.Exit(exitError)
+}
+
+func (l *errorLogger) Fatalln(v ...interface{}) {
+	l.Output(2, fmt.Sprintln(v...))
+	os.Exit(exitError)
+}
+
 func InitLogging(traceHandle io.Writer, infoHandle io.Writer, errorHandle io.Writer, printLines bool) {
 	flag := 0
 	if printLines {
@@ -23,6 +58,14 @@ func InitLogging(traceHandle io.Writer, infoHandle io.Writer, errorHandle io.Wri
 	Info = log.New(infoHandle,
 		"INFO: ", flag)
 
-	Error = log.New(errorHandle,
-		"ERROR: ", flag)
+	Error = &errorLogger{log.New(errorHandle,
+		"ERROR: ", flag)}
+}
+
+// Exits with exitHits if any entries were found, exitNoHits otherwise
+func exitWithHits(hits int64) {
+	if hits > 0 {
+		os.Exit(exitHits)
+	}
+	os.Exit(exitNoHits)
 }
@@ -12,8 +12,8 @@ import (
 	"time"
 
 	"github.com/codegangsta/cli"
+	"github.com/fatih/color"
 	"golang.org/x/crypto/ssh/terminal"
-	"gopkg.in/olivere/elastic.v2"
 )
 
 
@@ -21,23 +21,20 @@ import (
 var formatRegexp = regexp.MustCompile("%[A-Za-z0-9@_.-]+")
 var localTz, _ = time.LoadLocation("Local")
 
-const dateFormatDMY = "2006-01-02"
-
 // Create a new Tailer using configuration
 func NewTail(configuration *Configuration) *Tail {
 	tail := new(Tail)
+	tail.cursor = newTailCursor()
 
-	var client *elastic.Client
-	var err error
 	var url = configuration.SearchTarget.Url
 	if !strings.HasPrefix(url, "http") {
 		url = "http://" + url
-		Trace.Printf("Adding http:// prefix to given url. Url: " + url)
+		Trace.Printf("Adding http:// prefix to given url. Url: %s", url)
 	}
 
 	if !Must(regexp.MatchString(".*:\\d+", url)) && Must(regexp.MatchString("http://[^/]+$", url)) {
 		url += ":9200"
-		Trace.Printf("No port was specified, adding default port 9200 to given url. Url: " + url)
+		Trace.Printf("No port was specified, adding default port 9200 to given url. Url: %s", url)
 	}
 
 	//if a tunnel is successfully created, we need to connect to tunnel url (which is localhost on tunnel port)
@@ -45,49 +42,86 @@ func NewTail(configuration *Configuration) *Tail {
 		url = configuration.SearchTarget.TunnelUrl
 	}
 
-	defaultOptions := []elastic.ClientOptionFunc{
-		elastic.SetURL(url),
-		elastic.SetSniff(false),
-		elastic.SetHealthcheckTimeoutStartup(10 * time.Second),
-		elastic.SetHealthcheckTimeout(2 * time.Second),
-	}
+	tail.tailMode = configuration.TailMode
+	tail.listenMode = configuration.isListening()
 
-	if configuration.User != "" {
-		defaultOptions = append(defaultOptions,
-			elastic.SetBasicAuth(configuration.User, configuration.Password))
+	if (tail.tailMode) {
+		fmt.Fprintf(InfoOutput, "In Tail Mode... Starting with the most recent %d entries!\n", configuration.InitialEntries)
 	}
 
-	if configuration.TraceRequests {
-		defaultOptions = append(defaultOptions,
-			elastic.SetTraceLog(Trace))
+	var backend LogBackend
+	var err error
+	if tail.listenMode {
+		//events are pushed by Logstash, there's nothing to search
+	} else if configuration.isOffline() {
+		backend, err = NewLocalBackend(configuration.Files, configuration.QueryDefinition.TimestampField)
+		if err != nil {
+			Error.Fatalf("Could not read local files: %s\n", err)
+		}
+		//each file is an index whose time span is known from its entries
+		configuration.SearchTarget.IndexPattern = ""
+		configuration.SearchTarget.IndexNaming = IndexNamingCluster
+	} else {
+		//backend is chosen by probing the version of the cluster
+		backend, err = NewElasticsearchBackend(url, configuration.User, configuration.Password, configuration.TraceRequests)
+		if err != nil {
+			Error.Fatalf("Could not connect Elasticsearch client to %s: %s.", url, err)
+		}
 	}
+	tail.backend = backend
 
-	tail.tailMode = configuration.TailMode
+	tail.queryDefinition = &configuration.QueryDefinition
 
-	if (tail.tailMode) {
-		fmt.Printf("In Tail Mode... Starting with the most recent %d entries!\n", configuration.InitialEntries)
+	tail.watcher, err = newWatcher(tail.queryDefinition.Watch, tail.queryDefinition.WatchFields, configuration.WatchActions)
+	if err != nil {
+		Error.Fatalln(err)
 	}
+	tail.highlighter = newHighlighter(strings.Join(tail.queryDefinition.Terms, " "), tail.watcher)
 
-	client, err = elastic.NewClient(defaultOptions...)
+	if tail.tailMode || tail.listenMode {
+		tail.alerts, err = loadAlertRules(configuration.AlertRules, configuration.Profile)
+		if err != nil {
+			Error.Fatalln(err)
+		}
+	} else if configuration.AlertRules != "" {
+		Error.Fatalln("Alert rules are evaluated only in tail mode, please add -t option")
+	}
+	if tail.watcher != nil && tail.tailMode {
+		//entries fetched initially in tail mode are history, watch actions are taken only for new entries
+		tail.watcher.armed = false
+	}
 
+	tail.output, err = newEntryWriter(tail)
 	if err != nil {
-		Error.Fatalf("Could not connect Elasticsearch client to %s: %s.", url, err)
+		Error.Fatalln(err)
 	}
-	tail.client = client
-
-	tail.queryDefinition = &configuration.QueryDefinition
 
 	if (tail.tailMode) {
 		tail.queryDefinition.Duration = "2m"
 	}
 
-	if (tail.queryDefinition.RequestId != "") {
+	tail.traceMode = configuration.Commands.Trace
+	if (tail.traceMode) {
+		//trace searches the whole trace window, or all indices matching the pattern
+		if tail.queryDefinition.RequestId == "" {
+			Error.Fatalln("Please specify the request id to be traced using -id option")
+		}
+		if tail.queryDefinition.TraceWindow == TraceWindowAll {
+			tail.queryDefinition.Duration = ""
+		} else {
+			tail.queryDefinition.Duration = tail.queryDefinition.TraceWindow
+		}
+	} else if (tail.queryDefinition.RequestId != "") {
 		//if RequestId is specified, search today's index completely and get max 1000 entries
-		tail.queryDefinition.Duration = "24h"
+		if configuration.usesCluster() {
+			tail.queryDefinition.Duration = "24h"
+		}
 		configuration.InitialEntries = 1000
 	}
 
-	tail.selectIndices(configuration)
+	if !tail.listenMode {
+		tail.selectIndices(configuration)
+	}
 
 	//If we're date filtering on start date, then the sort needs to be ascending
 	if configuration.QueryDefinition.AfterDateTime != "" || configuration.QueryDefinition.Duration != "" {
@@ -99,18 +133,12 @@ func NewTail(configuration *Configuration) *Tail {
 }
 
 
-// Extracts and parses YMD date (year followed by month followed by day) from a given string. YMD values are separated by
-// separator character given as argument.
-func extractYMDDate(dateStr, separator string) time.Time {
-	dateRegexp := regexp.MustCompile(fmt.Sprintf(`(\d{4}%s\d{2}%s\d{2})`, separator, separator))
-	match := dateRegexp.FindAllStringSubmatch(dateStr, -1)
-	if len(match) == 0 {
-		Error.Fatalf("Failed to extract date: %s\n", dateStr)
-	}
-	result := match[0]
-	parsed, err := time.Parse(fmt.Sprintf("2006%s01%s02", separator, separator), result[0])
+// Parses timestamp in UTC produced by QueryDefinition (e.g. AfterDateTimeInUTC). Given timestamp is the one
+// specified by the user and is only used for error reporting.
+func parseUTCTimestamp(utcTimestamp, givenTimestamp string) time.Time {
+	parsed, err := time.Parse(time.RFC3339Nano, utcTimestamp)
 	if err != nil {
-		Error.Fatalf("Failed parsing date: %s", err)
+		Error.Fatalf("Failed parsing timestamp: %s\n", givenTimestamp)
 	}
 	return parsed
 }
@@ -125,6 +153,7 @@ func main() {
 	app.Version = VERSION
 	app.ArgsUsage = "'<search keyword(s)>'\n   Options marked with (*) are saved between invocations of the command. Each time you specify an option marked with (*) previously stored settings are erased."
 	app.Flags = config.Flags()
+	app.Commands = []cli.Command{profileCommand()}
 	app.Action = func(c *cli.Context) {
 
 		if c.IsSet("help") {
@@ -139,11 +168,55 @@ func main() {
 			InitLogging(ioutil.Discard, ioutil.Discard, os.Stderr, false)
 		}
 
+		if isMachineOutput(config.QueryDefinition.Output) {
+			//keep standard output clean for other tools
+			color.NoColor = true
+			InfoOutput = os.Stderr
+		} else if !terminal.IsTerminal(int(os.Stdout.Fd())) {
+			//output is piped or redirected, informational lines would mix with the entries
+			InfoOutput = os.Stderr
+		}
+
+		config.Files = c.StringSlice("file")
+		config.Listen = c.StringSlice("listen")
+		if config.isOffline() && config.isListening() {
+			Error.Fatalln("Please either search local files using --file or listen for events using --listen")
+		}
+		if config.isOffline() {
+			if config.TailMode {
+				Error.Fatalln("Tail mode is not available for local files")
+			}
+			//archived logs are searched as a whole unless a time window is given
+			if !c.IsSet("d") && !c.IsSet("duration") {
+				config.QueryDefinition.Duration = ""
+			}
+			if !c.IsSet("trace-window") {
+				config.QueryDefinition.TraceWindow = TraceWindowAll
+			}
+		}
+
+		if config.Timezone != "" {
+			location, err := time.LoadLocation(config.Timezone)
+			if err != nil {
+				Error.Fatalf("Unknown timezone %s: %s\n", config.Timezone, err)
+			}
+			localTz = location
+		}
+		if err := config.QueryDefinition.ResolveTimeExpressions(time.Now()); err != nil {
+			Error.Fatalln(err)
+		}
+
+		//default profile refers to another profile, which is loaded and saved instead
+		config.Profile = resolveProfileName(config.Profile)
+
 		if !IsConfigRelevantFlagSet(c) {
 			loadedConfig, err := LoadProfile(config.Profile)
-			if err != nil {
+			if err != nil && !config.usesCluster() {
+				//profile is optional for local files and listener, it only provides the format and saved query
+				Info.Printf("Not using profile %s: %s\n", config.Profile, err)
+			} else if err != nil {
 				Info.Printf("Failed to find or open previous default configuration: %s\n", err)
-				if config.Profile != "default" {
+				if config.Profile != defaultProfile {
 					Error.Fatalln("You have no configuration setup for profile " + config.Profile + ". Type --help for usage..")
 				} else {
 					Error.Fatalln("It seems like you do not have default profile setup. Please setup a profile by providing -p, -url, -default-profile options or type --help for all options")
@@ -162,17 +235,37 @@ func main() {
 				//	//Trace.Println(string(confJs))
 				//}
 			}
+		} else {
+			config.KeepProfileSettings(c.IsSet("index-naming"))
 		}
 
-		if config.User != "" {
+		if config.SaveTemplate != "" {
+			if config.QueryDefinition.Template == "" {
+				Error.Fatalln("Please specify the template to be saved using --template option")
+			}
+			if config.Templates == nil {
+				config.Templates = make(map[string]string)
+			}
+			config.Templates[config.SaveTemplate] = config.QueryDefinition.Template
+			fmt.Fprintf(InfoOutput, "Template %s saved in profile %s. Use --template @%s to apply it.\n", config.SaveTemplate, config.Profile, config.SaveTemplate)
+		}
+		if config.QueryDefinition.Template != "" {
+			template, err := resolveTemplate(config.QueryDefinition.Template, config.Templates)
+			if err != nil {
+				Error.Fatalln(err)
+			}
+			config.QueryDefinition.Template = template
+		}
+
+		if config.User != "" && config.usesCluster() {
 			fmt.Print("Enter password: ")
 			config.Password = readPasswd()
 		}
 
-		fmt.Println(paintSystemParams(config))
+		fmt.Fprintln(InfoOutput, paintSystemParams(config))
 		//reset TunnelUrl to nothing, we'll point to the tunnel if we actually manage to create it
 		config.SearchTarget.TunnelUrl = ""
-		if config.SSHTunnelParams != "" {
+		if config.SSHTunnelParams != "" && config.usesCluster() {
 			//We need to start ssh tunnel and make el client connect to local port at localhost in order to pass
 			//traffic through the tunnel
 			elurl, err := url.Parse(config.SearchTarget.Url)
@@ -203,11 +296,16 @@ func main() {
 			} else {
 				config.QueryDefinition.Terms = []string{}
 			}
+			config.QueryDefinition.Filters = c.StringSlice("F")
+			config.QueryDefinition.WatchFields = c.StringSlice("watch-field")
 			configToSave = config.Copy()
 			Trace.Printf("Saving query terms. Total terms: %d\n", len(configToSave.QueryDefinition.Terms))
 		} else {
 			Trace.Printf("Not saving query terms. Total terms: %d\n", len(config.QueryDefinition.Terms))
 			configToSave = config.Copy()
+			//filters given on command line are applied in addition to saved filters
+			config.QueryDefinition.Filters = append(config.QueryDefinition.Filters, c.StringSlice("F")...)
+			config.QueryDefinition.WatchFields = c.StringSlice("watch-field")
 			if args.Present() {
 				if len(config.QueryDefinition.Terms) > 1 {
 					config.QueryDefinition.Terms = append(config.QueryDefinition.Terms, "AND")
@@ -220,30 +318,85 @@ func main() {
 		}
 
 
-		if config.Commands.ListSources {
+		//number of entries found by batch mode and --count, decides the exit code
+		var hits int64 = -1
+
+		if config.isListening() {
+			tail := NewTail(config)
+			tail.Listen(config.Listen)
+		} else if config.Commands.ListSources {
 			tail := NewTail(config)
 			result, err := tail.ListAllSources()
 			if err != nil {
 				Error.Fatalln("Error in executing search query.", err)
 			}
 			tail.processSources(result)
+		} else if config.Commands.Trace {
+			tail := NewTail(config)
+			tail.TraceRequest()
+		} else if config.QueryDefinition.CompareTo != "" {
+			tail := NewTail(config)
+			tail.CompareTo(config.QueryDefinition.CompareTo)
+		} else if config.Commands.ListFields {
+			tail := NewTail(config)
+			tail.ListFields()
+		} else if config.QueryDefinition.TopFields != "" {
+			tail := NewTail(config)
+			tail.TopTerms()
+		} else if config.QueryDefinition.StatsField != "" {
+			tail := NewTail(config)
+			tail.FieldStats()
+		} else if config.Commands.Patterns {
+			tail := NewTail(config)
+			tail.Patterns()
+		} else if config.Commands.Histogram {
+			tail := NewTail(config)
+			tail.Histogram()
+		} else if config.Commands.Count {
+			tail := NewTail(config)
+			hits = tail.Count()
+		} else if config.QueryDefinition.Export != "" {
+			tail := NewTail(config)
+			tail.Export(config.QueryDefinition.Export, config.Profile, config.SearchTarget.Url)
+		} else if config.Commands.Explain || config.Commands.ValidateQuery {
+			tail := NewTail(config)
+			tail.Explain(config.InitialEntries, config.Commands.ValidateQuery)
 		} else if config.Commands.DefaultProfile {
 			if config.Profile == "" {
 				Error.Fatalln("Please specify the profile to be set as default using -p or --profile option")
 			} else {
 				setupDefaultProfile(config.Profile)
 			}
+		} else if config.Commands.Browse {
+			tail := NewTail(config)
+			tail.Browse(config.InitialEntries)
 		} else {
 			tail := NewTail(config)
-			tail.Start(config.InitialEntries)
+			if config.QueryDefinition.Template == "" {
+				tail.WarnUnknownFormatFields()
+			}
+			if config.isBatchMode() {
+				hits = tail.Batch(config.InitialEntries)
+			} else {
+				tail.Start(config.InitialEntries)
+			}
 		}
 
-		//If we don't exit here we can save the defaults
-		configToSave.SaveDefault()
+		//If we don't exit here we can save the defaults. Offline and listen runs don't touch the profile, it may not
+		//even exist.
+		if config.usesCluster() {
+			configToSave.SaveDefault()
+		}
+
+		if hits >= 0 {
+			exitWithHits(hits)
+		}
 
 	}
 
-	app.Run(os.Args)
+	if err := app.Run(os.Args); err != nil {
+		os.Exit(exitError)
+	}
 
 }
 
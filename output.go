@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Supported output formats. Text output uses the message format with colors, the other ones are meant to be
+// processed by other tools and never contain colors.
+const (
+	OutputText   = "text"
+	OutputJSON   = "json"
+	OutputCSV    = "csv"
+	OutputTSV    = "tsv"
+	OutputLogfmt = "logfmt"
+)
+
+//
+// Writes fetched entries to the standard output in one of the output formats.
+//
+type entryWriter interface {
+	WriteEntry(hit *SearchHit, entry map[string]interface{})
+}
+
+// Creates writer for the output format of the tail. Columns of CSV, TSV and logfmt outputs are the fields
+// referenced in the message format.
+func newEntryWriter(t *Tail) (entryWriter, error) {
+	fields := formatFields(t.queryDefinition.Format)
+	if t.queryDefinition.Template != "" {
+		if isMachineOutput(t.queryDefinition.Output) {
+			return nil, fmt.Errorf("Template can only be used with %s output", OutputText)
+		}
+		return newTemplateEntryWriter(t.queryDefinition.Template)
+	}
+	switch t.queryDefinition.Output {
+	case "", OutputText:
+		return &textEntryWriter{tail: t}, nil
+	case OutputJSON:
+		return &jsonEntryWriter{includeMeta: t.queryDefinition.OutputMeta}, nil
+	case OutputCSV:
+		return &delimitedEntryWriter{fields: fields, writer: csv.NewWriter(os.Stdout)}, nil
+	case OutputTSV:
+		return &delimitedEntryWriter{fields: fields, tsv: true}, nil
+	case OutputLogfmt:
+		return &logfmtEntryWriter{fields: fields}, nil
+	}
+	return nil, fmt.Errorf("Unknown output format %s. Supported formats: %s, %s, %s, %s and %s", t.queryDefinition.Output,
+		OutputText, OutputJSON, OutputCSV, OutputTSV, OutputLogfmt)
+}
+
+// Returns true if the output format is meant for other tools rather than humans
+func isMachineOutput(output string) bool {
+	return output != "" && output != OutputText
+}
+
+// Returns names of the fields referenced in the message format (without the % sign)
+func formatFields(format string) []string {
+	fields := formatRegexp.FindAllString(format, -1)
+	for i, f := range fields {
+		fields[i] = f[1:]
+	}
+	return fields
+}
+
+// -- Text --
+
+type textEntryWriter struct {
+	tail *Tail
+}
+
+func (w *textEntryWriter) WriteEntry(hit *SearchHit, entry map[string]interface{}) {
+	w.tail.printResult(entry, hit.Highlight)
+}
+
+// -- NDJSON --
+
+// Writes _source of each entry as a single line of JSON, optionally with _index and _id of the entry
+type jsonEntryWriter struct {
+	includeMeta bool
+}
+
+func (w *jsonEntryWriter) WriteEntry(hit *SearchHit, entry map[string]interface{}) {
+	if !w.includeMeta {
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, hit.Source); err == nil {
+			fmt.Println(compacted.String())
+			return
+		}
+	}
+	withMeta := make(map[string]interface{}, len(entry)+2)
+	for key, value := range entry {
+		withMeta[key] = value
+	}
+	if w.includeMeta {
+		withMeta["_index"] = hit.Index
+		withMeta["_id"] = hit.Id
+	}
+	line, err := json.Marshal(withMeta)
+	if err != nil {
+		Error.Println("Failed to marshal entry to json.", err)
+		return
+	}
+	fmt.Println(string(line))
+}
+
+// -- CSV and TSV --
+
+// Writes values of the format fields separated by commas (quoted as needed) or tabs (with tabs and newlines escaped).
+// Header with field names is written before the first entry.
+type delimitedEntryWriter struct {
+	fields        []string
+	writer        *csv.Writer
+	tsv           bool
+	headerWritten bool
+}
+
+func (w *delimitedEntryWriter) WriteEntry(hit *SearchHit, entry map[string]interface{}) {
+	if !w.headerWritten {
+		w.writeRecord(w.fields)
+		w.headerWritten = true
+	}
+	record := make([]string, len(w.fields))
+	for i, field := range w.fields {
+		record[i] = fieldValue(entry, field)
+	}
+	w.writeRecord(record)
+}
+
+func (w *delimitedEntryWriter) writeRecord(record []string) {
+	if w.tsv {
+		escaped := make([]string, len(record))
+		for i, value := range record {
+			escaped[i] = tsvEscaper.Replace(value)
+		}
+		fmt.Println(strings.Join(escaped, "\t"))
+		return
+	}
+	w.writer.Write(record)
+	w.writer.Flush()
+}
+
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// -- logfmt --
+
+// Writes format fields as key=value pairs, values are quoted when needed
+type logfmtEntryWriter struct {
+	fields []string
+}
+
+func (w *logfmtEntryWriter) WriteEntry(hit *SearchHit, entry map[string]interface{}) {
+	pairs := make([]string, 0, len(w.fields))
+	for _, field := range w.fields {
+		pairs = append(pairs, field+"="+logfmtValue(fieldValue(entry, field)))
+	}
+	fmt.Println(strings.Join(pairs, " "))
+}
+
+func logfmtValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " =\"\\\t\r\n") {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// -- Field values --
+
+// Looks up the field using dot syntax (see EvaluateExpression). Returns false if the field does not exist.
+func lookupField(model interface{}, fieldExpression string) (interface{}, bool) {
+	for _, part := range strings.Split(fieldExpression, ".") {
+		modelMap, ok := model.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		model, ok = modelMap[part]
+		if !ok || model == nil {
+			return nil, false
+		}
+	}
+	return model, true
+}
+
+// Returns value of the field as string suitable for machine output. Missing fields are empty, objects and arrays
+// are written as JSON and numbers are never written in exponent notation.
+func fieldValue(entry map[string]interface{}, field string) string {
+	value, ok := lookupField(entry, field)
+	if !ok {
+		return ""
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string]interface{}, []interface{}:
+		encoded, err := json.Marshal(v)
+		if err == nil {
+			return string(encoded)
+		}
+	}
+	return fmt.Sprintf("%v", value)
+}
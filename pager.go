@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const pagerHelp = "[n]ext [p]revious [N]ewest [t]ail [s]ize [c]olumn [v]iew in $PAGER [q]uit"
+
+// Pager used to view the current page when PAGER is not set
+const defaultPager = "less -R"
+
+// Page of entries in ascending order
+type pageEntry struct {
+	hit       *SearchHit
+	entry     map[string]interface{}
+	timestamp string
+}
+
+//
+// Prompts the user for keys controlling paging through the results when not tailing. Pager sits between the tail
+// and the entry writer, so that it knows which entries are on the current page.
+//
+type pager struct {
+	tail     *Tail
+	writer   entryWriter
+	pageSize int
+	page     []*pageEntry
+	pending  []*pageEntry //entries written since the last page turn
+	// Uids of entries at the timestamp of the first entry of the page, seen on this page and the following pages when
+	// paging back. Needed when more than a page of entries shares the timestamp.
+	boundary     string
+	boundarySeen map[string]bool
+}
+
+func newPager(t *Tail, pageSize int) *pager {
+	p := &pager{tail: t, writer: t.output, pageSize: pageSize}
+	t.output = p
+	return p
+}
+
+func (p *pager) WriteEntry(hit *SearchHit, entry map[string]interface{}) {
+	timestamp, _ := entry[p.tail.queryDefinition.TimestampField].(string)
+	p.pending = append(p.pending, &pageEntry{hit: hit, entry: entry, timestamp: timestamp})
+	p.writer.WriteEntry(hit, entry)
+}
+
+// Entries written since the last page turn become the current page. Returns false if there were none.
+func (p *pager) turnPage() bool {
+	if len(p.pending) == 0 {
+		return false
+	}
+	p.page = p.pending
+	p.pending = nil
+	return true
+}
+
+// Reads keys until the user quits or switches to tail mode
+func (p *pager) prompt() {
+	p.turnPage()
+	for {
+		fmt.Fprintf(InfoOutput, "%s ", paintInfoline(pagerHelp))
+		k, err := readSingleKey()
+		if isInteractive() {
+			fmt.Fprint(InfoOutput, "\r\033[K") //prompt is replaced by the entries
+		}
+		if err != nil {
+			return
+		}
+		switch k {
+		case 'n', 'm', ' ', keyDown, keyPageDown:
+			p.next()
+		case 'p', keyUp, keyPageUp:
+			p.previous()
+		case 'N', 'G', keyEnd:
+			p.newest()
+		case 't', 'f':
+			p.switchToTail()
+			return
+		case 's':
+			p.changePageSize()
+		case 'c':
+			p.toggleColumn()
+		case 'v':
+			p.viewInPager()
+		case 'q', keyEscape, keyInterrupt:
+			return
+		default:
+			//stray keys are ignored rather than ending the session
+			if k > 0 {
+				fmt.Fprintln(InfoOutput, paintInfoline(fmt.Sprintf("Unknown key %s", string(rune(k)))))
+			}
+		}
+	}
+}
+
+// Prints entries following the current page
+func (p *pager) next() {
+	//pages are fetched until one of them has new entries, as a page may consist of entries that were already seen
+	_, err := p.tail.followCursor(p.pageSize, 1, func(result *SearchResponse) int {
+		processed := 0
+		//pages following the cursor are always in ascending order
+		for _, hit := range result.Hits {
+			if p.tail.processHit(hit) {
+				processed++
+			}
+		}
+		return processed
+	})
+	if err != nil {
+		Error.Fatalln("Error in executing search query.", err)
+	}
+	p.boundarySeen = nil
+	if !p.turnPage() {
+		fmt.Fprintln(InfoOutput, paintInfoline("No newer entries"))
+	}
+}
+
+// Prints entries preceding the current page. Watch actions are not taken again for these entries.
+func (p *pager) previous() {
+	if len(p.page) == 0 {
+		fmt.Fprintln(InfoOutput, paintInfoline("No older entries"))
+		return
+	}
+	boundary := p.page[0].timestamp
+	exclude := map[string]bool{}
+	if boundary == p.boundary && p.boundarySeen != nil {
+		exclude = p.boundarySeen
+	}
+	for _, e := range p.page {
+		if e.timestamp == boundary {
+			exclude[e.hit.Uid()] = true
+		}
+	}
+	hits, err := p.tail.fetchPageBefore(boundary, exclude, p.pageSize)
+	if err != nil {
+		Error.Fatalln("Error in executing search query.", err)
+	}
+	if len(hits) == 0 {
+		fmt.Fprintln(InfoOutput, paintInfoline("No older entries"))
+		return
+	}
+	p.boundary, p.boundarySeen = boundary, exclude
+	fmt.Fprintln(InfoOutput, paintInfoline("Older entries:"))
+	if p.tail.watcher != nil {
+		p.tail.watcher.armed = false
+		defer func() { p.tail.watcher.armed = true }()
+	}
+	p.showDescending(hits)
+}
+
+// Prints the most recent entries matching the query
+func (p *pager) newest() {
+	hits, err := p.tail.fetchPageBefore("", nil, p.pageSize)
+	if err != nil {
+		Error.Fatalln("Error in executing search query.", err)
+	}
+	fmt.Fprintln(InfoOutput, paintInfoline("Newest entries:"))
+	p.boundarySeen = nil
+	p.showDescending(hits)
+}
+
+// Prints hits given in descending order as the new page. Cursor is moved to the end of the page, so that the next
+// page follows it.
+func (p *pager) showDescending(hits []*SearchHit) {
+	p.tail.cursor = newTailCursor()
+	for i := len(hits) - 1; i >= 0; i-- {
+		p.tail.processHit(hits[i])
+	}
+	p.turnPage()
+}
+
+// Continues with tail mode from the newest entries
+func (p *pager) switchToTail() {
+	p.newest()
+	fmt.Fprintln(InfoOutput, paintInfoline("Tailing... press Ctrl+C to quit"))
+	p.tail.tailMode = true
+	p.tail.InfinitelyTail(p.pageSize)
+}
+
+func (p *pager) changePageSize() {
+	input, ok := readLine(fmt.Sprintf("Page size (%d): ", p.pageSize))
+	if !ok || strings.TrimSpace(input) == "" {
+		return
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(input))
+	if err != nil || size <= 0 {
+		fmt.Fprintln(InfoOutput, paintInfoline(fmt.Sprintf("Invalid page size %s", input)))
+		return
+	}
+	p.pageSize = size
+}
+
+// Adds the field to the message format or removes it if it is already there and prints the current page again
+func (p *pager) toggleColumn() {
+	input, ok := readLine("Field to show or hide: ")
+	field := strings.TrimPrefix(strings.TrimSpace(input), "%")
+	if !ok || field == "" {
+		return
+	}
+	q := p.tail.queryDefinition
+	q.Format = toggleFormatField(q.Format, field)
+	writer, err := newEntryWriter(p.tail)
+	if err != nil {
+		Error.Println(err)
+		return
+	}
+	p.writer = writer
+	for _, e := range p.page {
+		p.writer.WriteEntry(e.hit, e.entry)
+	}
+}
+
+// Removes all references of the field from the format, or appends the field if the format does not reference it
+func toggleFormatField(format string, field string) string {
+	reference := "%" + field
+	removed := false
+	toggled := formatRegexp.ReplaceAllStringFunc(format, func(f string) string {
+		if f == reference {
+			removed = true
+			return ""
+		}
+		return f
+	})
+	if !removed {
+		return strings.TrimSpace(format) + " " + reference
+	}
+	return strings.Join(strings.Fields(toggled), " ")
+}
+
+// Opens the current page in the pager given by PAGER environment variable
+func (p *pager) viewInPager() {
+	if len(p.page) == 0 {
+		return
+	}
+	var page bytes.Buffer
+	for _, e := range p.page {
+		if isMachineOutput(p.tail.queryDefinition.Output) {
+			page.Write(entryJSON(e.hit, e.entry))
+		} else {
+			page.WriteString(p.tail.formatEntry(e.entry, e.hit.Highlight) + "\n")
+		}
+	}
+	command := os.Getenv("PAGER")
+	if command == "" {
+		command = defaultPager
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = &page
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		Error.Printf("Pager %s failed: %s\n", command, err)
+	}
+}
+
+// Fetches page of entries preceding the timestamp (most recent entries if the timestamp is empty), sorted in
+// descending order. Entries at the timestamp are included unless excluded by uid, so that entries sharing the
+// boundary timestamp with the current page are not lost.
+func (t *Tail) fetchPageBefore(timestamp string, exclude map[string]bool, size int) ([]*SearchHit, error) {
+	request := t.initialSearchRequest(size + len(exclude))
+	request.Sort = []SortField{{Field: t.queryDefinition.TimestampField, Ascending: false}}
+	if timestamp != "" {
+		request.Query.Filters = append(request.Query.Filters, RangeFilter{Field: t.queryDefinition.TimestampField, Lte: timestamp})
+	}
+	result, err := t.backend.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	hits := []*SearchHit{}
+	for _, hit := range result.Hits {
+		if !exclude[hit.Uid()] && len(hits) < size {
+			hits = append(hits, hit)
+		}
+	}
+	return hits, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Field clustered into patterns
+const patternField = "message"
+
+// Maximum number of entries clustered into patterns
+const patternMaxEntries = 100000
+
+// Number of the most frequent patterns printed
+const patternsShown = 50
+
+const patternExampleLength = 200
+
+// Variable tokens masked in messages, applied in order. Quoted strings go first so that tokens inside them are not
+// masked separately, hex goes before numbers so that hashes and ids are masked as a whole.
+var patternMasks = []struct {
+	regexp      *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`"[^"]*"|'[^']*'`), `"<str>"`},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`\b\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}(:\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`\b(0[xX][0-9a-fA-F]+|[0-9a-fA-F]{8,})\b`), "<hex>"},
+	{regexp.MustCompile(`\b\d+(\.\d+)?`), "<num>"},
+}
+
+var whitespaceRegexp = regexp.MustCompile(`\s+`)
+
+type messagePattern struct {
+	pattern string
+	count   int
+	first   time.Time
+	last    time.Time
+	example string
+}
+
+//
+// Collects fetched entries into patterns instead of printing them
+//
+type patternCollector struct {
+	timestampField string
+	patterns       map[string]*messagePattern
+	entries        int
+}
+
+func (c *patternCollector) WriteEntry(hit *SearchHit, entry map[string]interface{}) {
+	message := fieldValue(entry, patternField)
+	pattern := maskMessage(message)
+	timestamp, _ := time.Parse(time.RFC3339Nano, fieldValue(entry, c.timestampField))
+
+	p, ok := c.patterns[pattern]
+	if !ok {
+		example := []rune(message)
+		if len(example) > patternExampleLength {
+			example = append(example[:patternExampleLength], []rune("...")...)
+		}
+		p = &messagePattern{pattern: pattern, first: timestamp, example: string(example)}
+		c.patterns[pattern] = p
+	}
+	p.count++
+	if timestamp.Before(p.first) {
+		p.first = timestamp
+	}
+	if timestamp.After(p.last) {
+		p.last = timestamp
+	}
+	c.entries++
+}
+
+// Replaces variable tokens of the message with placeholders, so that messages logged by the same statement share
+// the pattern
+func maskMessage(message string) string {
+	masked := whitespaceRegexp.ReplaceAllString(strings.TrimSpace(message), " ")
+	for _, mask := range patternMasks {
+		masked = mask.regexp.ReplaceAllStringFunc(masked, func(token string) string {
+			if mask.replacement == "<hex>" && !isHexToken(token) {
+				return token
+			}
+			return mask.replacement
+		})
+	}
+	return masked
+}
+
+// Long tokens of hex digits are hex only if they mix digits and letters, plain numbers are masked as numbers and
+// plain letters are words
+func isHexToken(token string) bool {
+	if strings.HasPrefix(token, "0x") || strings.HasPrefix(token, "0X") {
+		return true
+	}
+	return strings.ContainsAny(token, "0123456789") && strings.ContainsAny(token, "abcdefABCDEF")
+}
+
+// Streams all the entries matching the query in the time window and prints patterns of their messages sorted by
+// frequency, with first and last time seen and an example message.
+func (t *Tail) Patterns() {
+	collector := t.collectPatterns()
+	if collector.entries == 0 {
+		fmt.Fprintln(InfoOutput, paintInfoline("No entries found"))
+		return
+	}
+	printPatterns(collector)
+}
+
+// Fetches entries matching the query in the time window and collects them into patterns
+func (t *Tail) collectPatterns() *patternCollector {
+	collector := &patternCollector{timestampField: t.queryDefinition.TimestampField,
+		patterns: make(map[string]*messagePattern)}
+	t.output = collector
+	t.cursor = newTailCursor()
+	//pages following the cursor are always in ascending order
+	t.order = true
+
+	if _, err := t.drainNewEntries(tailPageSize, patternMaxEntries); err != nil {
+		Error.Fatalln("Error in executing search query.", err)
+	}
+	if collector.entries >= patternMaxEntries {
+		fmt.Fprintln(InfoOutput, paintInfoline(fmt.Sprintf("Only the first %d entries were clustered", collector.entries)))
+	}
+	return collector
+}
+
+func printPatterns(collector *patternCollector) {
+	patterns := make([]*messagePattern, 0, len(collector.patterns))
+	for _, p := range collector.patterns {
+		patterns = append(patterns, p)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if patterns[i].count != patterns[j].count {
+			return patterns[i].count > patterns[j].count
+		}
+		return patterns[i].first.Before(patterns[j].first)
+	})
+
+	fmt.Println(paintInfoline(fmt.Sprintf("%d patterns in %d entries", len(patterns), collector.entries)))
+	countWidth := len(fmt.Sprintf("%d", patterns[0].count))
+	for i, p := range patterns {
+		if i == patternsShown {
+			fmt.Println(paintInfoline(fmt.Sprintf("... and %d less frequent patterns", len(patterns)-patternsShown)))
+			break
+		}
+		fmt.Printf("%*d  %s - %s  %s\n", countWidth, p.count, color.GreenString(formatPatternTime(p.first)),
+			color.GreenString(formatPatternTime(p.last)), highlightPattern(p.pattern))
+		fmt.Printf("%*s  e.g. %s\n", countWidth, "", p.example)
+	}
+}
+
+func formatPatternTime(timestamp time.Time) string {
+	if timestamp.IsZero() {
+		return "-"
+	}
+	return timestamp.In(localTz).Format("2006-01-02 15:04:05")
+}
+
+var placeholderRegexp = regexp.MustCompile(`<(str|uuid|ip|hex|num)>`)
+
+// Paints placeholders, so that the constant part of the pattern stands out
+func highlightPattern(pattern string) string {
+	return placeholderRegexp.ReplaceAllStringFunc(pattern, paintRequestId)
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/codegangsta/cli"
+)
+
+// Profile used when -p option is not given. It refers to the profile chosen using profile use (or --set-as-default).
+const defaultProfile = "default"
+
+// File in the configuration directory holding the name of the profile the default profile refers to. Older versions
+// copied the profile to default.json instead, such copies are still understood and replaced by the reference when
+// profiles are managed.
+const defaultProfileReference = "default-profile"
+
+// Editor used by profile edit when neither VISUAL nor EDITOR is set
+const defaultEditor = "vi"
+
+func profilesDir() string {
+	return userHomeDir() + string(os.PathSeparator) + confDir
+}
+
+func profilePath(profile string) string {
+	return profilesDir() + string(os.PathSeparator) + profile + ".json"
+}
+
+func profileExists(profile string) bool {
+	_, err := os.Stat(profilePath(profile))
+	return err == nil
+}
+
+// Resolves default profile to the profile it refers to, other profiles are returned as they are
+func resolveProfileName(profile string) string {
+	if profile != defaultProfile {
+		return profile
+	}
+	if content, err := ioutil.ReadFile(profilesDir() + string(os.PathSeparator) + defaultProfileReference); err == nil {
+		if referenced := strings.TrimSpace(string(content)); referenced != "" {
+			return referenced
+		}
+	}
+	if copied, ok := legacyDefaultCopy(); ok {
+		return copied
+	}
+	return profile
+}
+
+// Returns the name of the profile default.json was copied from by older versions, if the profile still exists
+func legacyDefaultCopy() (string, bool) {
+	content, err := ioutil.ReadFile(profilePath(defaultProfile))
+	if err != nil {
+		return "", false
+	}
+	var copied struct{ Profile string }
+	if json.Unmarshal(content, &copied) != nil || copied.Profile == "" || copied.Profile == defaultProfile ||
+		!profileExists(copied.Profile) {
+		return "", false
+	}
+	return copied.Profile, true
+}
+
+// Replaces the copy of the default profile made by older versions with a reference, so that the copy does not
+// diverge from the original any more
+func migrateDefaultProfile() {
+	copied, ok := legacyDefaultCopy()
+	if !ok {
+		return
+	}
+	if err := writeDefaultProfileReference(copied); err != nil {
+		Error.Printf("Failed to migrate default profile: %s\n", err)
+		return
+	}
+	removeLegacyDefaultCopy(copied)
+}
+
+// Removes the copy of the profile made by older versions. Copies that were edited by hand (differ from the profile)
+// are kept as default.json.bak, so that the changes are not lost.
+func removeLegacyDefaultCopy(copied string) {
+	path := profilePath(defaultProfile)
+	copySettings, err := readProfile(defaultProfile)
+	if err != nil {
+		Error.Printf("Failed to read copy of default profile: %s\n", err)
+		return
+	}
+	settings, err := readProfile(copied)
+	if err == nil && reflect.DeepEqual(copySettings, settings) {
+		if err := os.Remove(path); err != nil {
+			Error.Printf("Failed to remove copy of default profile: %s\n", err)
+		}
+		return
+	}
+	if err := os.Rename(path, path+".bak"); err != nil {
+		Error.Printf("Failed to keep copy of default profile: %s\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s differs from profile %s it was copied from, it was kept as %s.bak. Default profile now "+
+		"refers to %s, copy the changes over using profile edit %s if you need them.\n", path, copied, path, copied, copied)
+}
+
+// Default profile refers to the profile. Reference is removed when the profile is the default profile itself.
+func writeDefaultProfileReference(profile string) error {
+	path := profilesDir() + string(os.PathSeparator) + defaultProfileReference
+	if profile == defaultProfile {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(path, []byte(profile+"\n"), 0600)
+}
+
+// Names of all the profiles, the reference and the copy of the default profile are left out
+func profileNames() ([]string, error) {
+	files, err := ioutil.ReadDir(profilesDir())
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, legacyCopy := legacyDefaultCopy()
+	names := []string{}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasSuffix(name, alertRulesSuffix) {
+			continue
+		}
+		name = strings.TrimSuffix(name, ".json")
+		if name == defaultProfile && legacyCopy {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func validateProfileName(profile string) error {
+	switch {
+	case profile == "":
+		return fmt.Errorf("Please specify the profile name")
+	case profile == defaultProfile:
+		return fmt.Errorf("Profile name %s is reserved, use profile use <name> to choose the default profile", profile)
+	case strings.ContainsAny(profile, `/\`) || strings.HasPrefix(profile, "."):
+		return fmt.Errorf("Invalid profile name %s", profile)
+	}
+	return nil
+}
+
+// Profiles are read and written as generic JSON, so that no settings are lost when copying or renaming
+func readProfile(profile string) (map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(profilePath(profile))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("Profile %s does not exist", profile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var settings map[string]interface{}
+	if err := json.Unmarshal(content, &settings); err != nil {
+		return nil, fmt.Errorf("Profile %s is not valid JSON: %s", profile, err)
+	}
+	return settings, nil
+}
+
+func writeProfile(profile string, settings map[string]interface{}) error {
+	settings["Profile"] = profile
+	content, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(profilesDir(), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(profilePath(profile), content, 0600)
+}
+
+// Returns the profile name given as the argument at the index, default profile is resolved
+func profileArgument(c *cli.Context, index int, usage string) string {
+	profile := c.Args().Get(index)
+	if profile == "" {
+		Error.Fatalf("Please specify the profile name (profile %s)\n", usage)
+	}
+	return resolveProfileName(profile)
+}
+
+func requireProfile(profile string) {
+	if !profileExists(profile) {
+		Error.Fatalf("Profile %s does not exist\n", profile)
+	}
+}
+
+// Subcommands managing profiles stored in ~/.logstasher
+func profileCommand() cli.Command {
+	created := new(Configuration)
+	return cli.Command{
+		Name:  "profile",
+		Usage: "Manage profiles - list, show, create, edit, delete, rename, copy, diff or use (choose the default profile)",
+		Before: func(c *cli.Context) error {
+			InitLogging(ioutil.Discard, ioutil.Discard, os.Stderr, false)
+			migrateDefaultProfile()
+			return nil
+		},
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List profiles with URL, index pattern, authentication and SSH tunnel, the default profile is marked by *",
+				Action: listProfiles,
+			},
+			{
+				Name:      "show",
+				Usage:     "Print settings of the profile (default profile if not given)",
+				ArgsUsage: "[name]",
+				Action:    showProfile,
+			},
+			{
+				Name:      "create",
+				Usage:     "Create profile, settings not given use the same defaults as the main command",
+				ArgsUsage: "<name>",
+				Flags:     created.profileFlags(),
+				Action: func(c *cli.Context) {
+					createProfile(c, created)
+				},
+			},
+			{
+				Name:      "edit",
+				Usage:     "Edit the profile in $VISUAL or $EDITOR, the profile is changed only if the result is valid",
+				ArgsUsage: "<name>",
+				Action:    editProfile,
+			},
+			{
+				Name:      "delete",
+				Usage:     "Delete the profile together with its alert rules",
+				ArgsUsage: "<name>",
+				Flags: []cli.Flag{
+					cli.BoolFlag{Name: "y,yes", Usage: "Don't ask for confirmation"},
+				},
+				Action: deleteProfile,
+			},
+			{
+				Name:      "rename",
+				Usage:     "Rename the profile, the default profile keeps referring to it",
+				ArgsUsage: "<name> <new name>",
+				Action:    renameProfile,
+			},
+			{
+				Name:      "copy",
+				Usage:     "Copy the profile, e.g. to set up a similar environment",
+				ArgsUsage: "<name> <new name>",
+				Action:    copyProfile,
+			},
+			{
+				Name:      "diff",
+				Usage:     "Print settings that differ between the profiles",
+				ArgsUsage: "<name> <other name>",
+				Action:    diffProfiles,
+			},
+			{
+				Name:      "use",
+				Usage:     "Make the profile the default profile, used when -p is not given",
+				ArgsUsage: "<name>",
+				Action: func(c *cli.Context) {
+					setupDefaultProfile(profileArgument(c, 0, "use <name>"))
+				},
+			},
+		},
+	}
+}
+
+// Flags of the settings stored in profiles, taken from the main command so that the defaults are the same
+func (config *Configuration) profileFlags() []cli.Flag {
+	relevant := make(map[string]bool, len(configRelevantFlags))
+	for _, name := range configRelevantFlags {
+		relevant[name] = true
+	}
+	flags := []cli.Flag{}
+	for _, flag := range config.Flags() {
+		if f, ok := flag.(cli.StringFlag); ok && relevant[strings.Split(f.Name, ",")[0]] {
+			f.Hidden = false
+			flags = append(flags, f)
+		}
+	}
+	return flags
+}
+
+func listProfiles(c *cli.Context) {
+	names, err := profileNames()
+	if err != nil {
+		Error.Fatalln("Failed to list profiles.", err)
+	}
+	if len(names) == 0 {
+		fmt.Println(paintInfoline("No profiles found, create one using profile create <name> --url <url>"))
+		return
+	}
+	defaultName := resolveProfileName(defaultProfile)
+	rows := [][]string{{"", "profile", "url", "index pattern", "auth", "tunnel"}}
+	for _, name := range names {
+		marker := " "
+		if name == defaultName {
+			marker = "*"
+		}
+		var config Configuration
+		content, err := ioutil.ReadFile(profilePath(name))
+		if err == nil {
+			err = json.Unmarshal(content, &config)
+		}
+		if err != nil {
+			rows = append(rows, []string{marker, name, "(invalid: " + err.Error() + ")", "", "", ""})
+			continue
+		}
+		auth, tunnel := "none", "-"
+		if config.User != "" {
+			auth = "basic (" + strings.SplitN(config.User, ":", 2)[0] + ")" //password is not shown
+		}
+		if config.SSHTunnelParams != "" {
+			tunnel = config.SSHTunnelParams
+		}
+		rows = append(rows, []string{marker, name, config.SearchTarget.Url, config.SearchTarget.IndexPattern, auth, tunnel})
+	}
+	printProfileTable(rows)
+}
+
+// Prints rows as columns padded to the widest cell, the first row is the header
+func printProfileTable(rows [][]string) {
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	for r, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = fmt.Sprintf("%-*s", widths[i], cell)
+		}
+		line := strings.TrimRight(strings.Join(cells, "  "), " ")
+		if r == 0 {
+			line = paintInfoline(line)
+		}
+		fmt.Println(line)
+	}
+}
+
+func showProfile(c *cli.Context) {
+	profile := defaultProfile
+	if c.Args().Present() {
+		profile = c.Args().First()
+	}
+	resolved := resolveProfileName(profile)
+	content, err := ioutil.ReadFile(profilePath(resolved))
+	if err != nil {
+		Error.Fatalf("Profile %s does not exist\n", resolved)
+	}
+	if resolved != profile {
+		fmt.Println(paintInfoline(fmt.Sprintf("%s refers to %s", profile, resolved)))
+	}
+	fmt.Println(paintInfoline(profilePath(resolved)))
+	fmt.Println(strings.TrimSpace(string(content)))
+}
+
+func createProfile(c *cli.Context, config *Configuration) {
+	profile := c.Args().First()
+	if err := validateProfileName(profile); err != nil {
+		Error.Fatalln(err)
+	}
+	if profileExists(profile) {
+		Error.Fatalf("Profile %s already exists, use profile edit %s to change it\n", profile, profile)
+	}
+	config.Profile = profile
+	config.SaveDefault()
+	fmt.Printf("Profile %s created.\n", profile)
+}
+
+// Profile is edited in a temporary file, which replaces the profile only if it is valid
+func editProfile(c *cli.Context) {
+	profile := profileArgument(c, 0, "edit <name>")
+	requireProfile(profile)
+	content, _ := ioutil.ReadFile(profilePath(profile))
+	temp, err := ioutil.TempFile("", profile+"-*.json")
+	if err != nil {
+		Error.Fatalln("Failed to create temporary file.", err)
+	}
+	defer os.Remove(temp.Name())
+	temp.Write(content)
+	temp.Close()
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = defaultEditor
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$0"`, temp.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		Error.Fatalf("Editor %s failed, profile %s not changed: %s\n", editor, profile, err)
+	}
+
+	edited, _ := ioutil.ReadFile(temp.Name())
+	var settings map[string]interface{}
+	var config Configuration
+	if err := json.Unmarshal(edited, &settings); err != nil {
+		Error.Fatalf("Profile %s not changed, edited profile is not valid JSON: %s\n", profile, err)
+	}
+	if err := json.Unmarshal(edited, &config); err != nil {
+		Error.Fatalf("Profile %s not changed, edited profile is not valid: %s\n", profile, err)
+	}
+	if err := writeProfile(profile, settings); err != nil {
+		Error.Fatalf("Failed to save profile %s: %s\n", profile, err)
+	}
+	fmt.Printf("Profile %s saved.\n", profile)
+}
+
+func deleteProfile(c *cli.Context) {
+	profile := profileArgument(c, 0, "delete <name>")
+	requireProfile(profile)
+	if !c.Bool("yes") && isInteractive() {
+		answer, ok := readLine(fmt.Sprintf("Delete profile %s? [y/N] ", profile))
+		if !ok || !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			return
+		}
+	}
+	if err := os.Remove(profilePath(profile)); err != nil {
+		Error.Fatalf("Failed to delete profile %s: %s\n", profile, err)
+	}
+	if err := os.Remove(alertRulesPath(profile)); err != nil && !os.IsNotExist(err) {
+		Error.Printf("Failed to delete alert rules of profile %s: %s\n", profile, err)
+	}
+	fmt.Printf("Profile %s deleted.\n", profile)
+	if resolveProfileName(defaultProfile) == profile {
+		writeDefaultProfileReference(defaultProfile)
+		fmt.Println("It was the default profile, choose another one using profile use <name>.")
+	}
+}
+
+func renameProfile(c *cli.Context) {
+	profile := profileArgument(c, 0, "rename <name> <new name>")
+	renamed := c.Args().Get(1)
+	copyProfileTo(profile, renamed)
+	if err := os.Remove(profilePath(profile)); err != nil {
+		Error.Fatalf("Failed to remove profile %s: %s\n", profile, err)
+	}
+	if err := os.Rename(alertRulesPath(profile), alertRulesPath(renamed)); err != nil && !os.IsNotExist(err) {
+		Error.Printf("Failed to rename alert rules of profile %s: %s\n", profile, err)
+	}
+	if resolveProfileName(defaultProfile) == profile {
+		if err := writeDefaultProfileReference(renamed); err != nil {
+			Error.Printf("Failed to update default profile: %s\n", err)
+		}
+	}
+	fmt.Printf("Profile %s renamed to %s.\n", profile, renamed)
+}
+
+func copyProfile(c *cli.Context) {
+	profile := profileArgument(c, 0, "copy <name> <new name>")
+	copied := c.Args().Get(1)
+	copyProfileTo(profile, copied)
+	fmt.Printf("Profile %s copied to %s.\n", profile, copied)
+}
+
+func copyProfileTo(profile string, target string) {
+	if err := validateProfileName(target); err != nil {
+		Error.Fatalln(err)
+	}
+	if profileExists(target) {
+		Error.Fatalf("Profile %s already exists\n", target)
+	}
+	settings, err := readProfile(profile)
+	if err != nil {
+		Error.Fatalln(err)
+	}
+	if err := writeProfile(target, settings); err != nil {
+		Error.Fatalf("Failed to save profile %s: %s\n", target, err)
+	}
+}
+
+func diffProfiles(c *cli.Context) {
+	profile := profileArgument(c, 0, "diff <name> <other name>")
+	other := profileArgument(c, 1, "diff <name> <other name>")
+	settings, err := readProfile(profile)
+	if err != nil {
+		Error.Fatalln(err)
+	}
+	otherSettings, err := readProfile(other)
+	if err != nil {
+		Error.Fatalln(err)
+	}
+	values, otherValues := map[string]interface{}{}, map[string]interface{}{}
+	flattenSettings(values, "", settings)
+	flattenSettings(otherValues, "", otherSettings)
+	delete(values, "Profile")
+	delete(otherValues, "Profile")
+
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	for key := range otherValues {
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	rows := [][]string{{"setting", profile, other}}
+	for _, key := range keys {
+		value, ok := values[key]
+		otherValue, otherOk := otherValues[key]
+		if ok != otherOk || !reflect.DeepEqual(value, otherValue) {
+			rows = append(rows, []string{key, settingString(value, ok), settingString(otherValue, otherOk)})
+		}
+	}
+	if len(rows) == 1 {
+		fmt.Printf("Profiles %s and %s have the same settings.\n", profile, other)
+		return
+	}
+	printProfileTable(rows)
+}
+
+// Flattens nested settings to dot-paths, e.g. SearchTarget.Url
+func flattenSettings(flat map[string]interface{}, prefix string, settings map[string]interface{}) {
+	for key, value := range settings {
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			flattenSettings(flat, prefix+key+".", nested)
+		} else {
+			flat[prefix+key] = value
+		}
+	}
+}
+
+func settingString(value interface{}, ok bool) string {
+	if !ok {
+		return "-"
+	}
+	if s, isString := value.(string); isString {
+		return s
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Field searched by query string terms without field prefix, the same as default_field of the query sent to the cluster
+const queryDefaultField = "message"
+
+//
+// Query string evaluated on the client side, for entries that do not come from a cluster (local files, listener).
+// Supports the commonly used part of the query string syntax - terms and quoted phrases with optional field prefix,
+// wildcards * and ?, AND, OR, NOT, +, -, parentheses, ranges ([400 TO 499], {* TO 10}), comparisons (status:>=500)
+// and existence (_exists_:field, field:*). Terms are matched case-insensitively as whole tokens, approximating the
+// standard analyzer. Fuzziness, proximity and boosts are ignored.
+//
+type queryNode interface {
+	matches(entry map[string]interface{}) bool
+}
+
+type queryAll struct{}
+
+type queryAnd []queryNode
+
+type queryOr []queryNode
+
+type queryNot struct {
+	node queryNode
+}
+
+// Term or phrase searched in the field
+type queryTerm struct {
+	field  string
+	regexp *regexp.Regexp
+}
+
+type queryRegexp struct {
+	field  string
+	regexp *regexp.Regexp
+}
+
+type queryExists struct {
+	field string
+}
+
+type queryRange struct {
+	filter RangeFilter
+}
+
+// Parses the query string. Empty query string matches all entries.
+func parseQueryString(queryString string) (queryNode, error) {
+	p := &queryParser{tokens: lexQueryString(queryString)}
+	if len(p.tokens) == 0 {
+		return queryAll{}, nil
+	}
+	node, err := p.parseOr(queryDefaultField)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid query %s: %s", queryString, err)
+	}
+	if p.position < len(p.tokens) {
+		return nil, fmt.Errorf("Invalid query %s: unexpected %s", queryString, p.tokens[p.position])
+	}
+	return node, nil
+}
+
+// Returns true if the entry matches the query string and passes all the filters of the query
+func (q Query) matchesEntry(queryString queryNode, entry map[string]interface{}) bool {
+	for _, filter := range q.Filters {
+		if !filterMatches(filter, entry) {
+			return false
+		}
+	}
+	return queryString.matches(entry)
+}
+
+// Splits query string like tokenizeQueryString, but keeps parentheses as tokens of their own
+func lexQueryString(queryString string) []string {
+	tokens := []string{}
+	var current strings.Builder
+	inQuotes := false
+	escaped := false
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	for _, r := range queryString {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			current.WriteRune(r)
+			inQuotes = !inQuotes
+		case inQuotes:
+			current.WriteRune(r)
+		case unicode.IsSpace(r):
+			flush()
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type queryParser struct {
+	tokens   []string
+	position int
+}
+
+func (p *queryParser) peek() string {
+	if p.position < len(p.tokens) {
+		return p.tokens[p.position]
+	}
+	return ""
+}
+
+func (p *queryParser) next() string {
+	token := p.peek()
+	p.position++
+	return token
+}
+
+func (p *queryParser) parseOr(field string) (queryNode, error) {
+	or := queryOr{}
+	for {
+		node, err := p.parseAnd(field)
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, node)
+		if token := p.peek(); token != "OR" && token != "||" {
+			break
+		}
+		p.next()
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+// Terms without operator between them must all match (default_operator is and)
+func (p *queryParser) parseAnd(field string) (queryNode, error) {
+	and := queryAnd{}
+	for {
+		node, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, node)
+		token := p.peek()
+		if token == "AND" || token == "&&" {
+			p.next()
+			continue
+		}
+		if token == "" || token == ")" || token == "OR" || token == "||" {
+			break
+		}
+	}
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return and, nil
+}
+
+func (p *queryParser) parseUnary(field string) (queryNode, error) {
+	token := p.peek()
+	switch {
+	case token == "NOT":
+		p.next()
+		node, err := p.parseUnary(field)
+		return queryNot{node}, err
+	case len(token) > 1 && (token[0] == '-' || token[0] == '!'):
+		p.tokens[p.position] = token[1:]
+		node, err := p.parseUnary(field)
+		return queryNot{node}, err
+	case len(token) > 1 && token[0] == '+':
+		p.tokens[p.position] = token[1:]
+	}
+	return p.parsePrimary(field)
+}
+
+func (p *queryParser) parsePrimary(field string) (queryNode, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of query")
+	case token == "(":
+		return p.parseGroup(field)
+	case token == ")" || queryOperators[token]:
+		return nil, fmt.Errorf("unexpected %s", token)
+	}
+
+	if prefix := queryFieldPrefixRegexp.FindString(token); prefix != "" {
+		field = strings.TrimSuffix(prefix, ":")
+		token = token[len(prefix):]
+		if token == "" {
+			if p.peek() != "(" {
+				return nil, fmt.Errorf("missing value of field %s", field)
+			}
+			p.next()
+			return p.parseGroup(field)
+		}
+	}
+	if field == "_exists_" {
+		return queryExists{field: token}, nil
+	}
+	if strings.HasPrefix(token, "[") || strings.HasPrefix(token, "{") {
+		return p.parseRange(field, token)
+	}
+	return newQueryTerm(field, token)
+}
+
+func (p *queryParser) parseGroup(field string) (queryNode, error) {
+	node, err := p.parseOr(field)
+	if err != nil {
+		return nil, err
+	}
+	if p.next() != ")" {
+		return nil, fmt.Errorf("missing )")
+	}
+	return node, nil
+}
+
+// Parses range [lower TO upper], square brackets are inclusive and curly braces exclusive bounds. * is an open bound.
+func (p *queryParser) parseRange(field string, token string) (queryNode, error) {
+	lower := token[1:]
+	if lower == "" {
+		lower = p.next()
+	}
+	if p.next() != "TO" {
+		return nil, fmt.Errorf("expected TO in range of field %s", field)
+	}
+	upper := p.next()
+	if next := p.peek(); next == "]" || next == "}" {
+		upper += p.next() //closing bracket separated by space
+	}
+	if len(upper) < 2 || !strings.HasSuffix(upper, "]") && !strings.HasSuffix(upper, "}") {
+		return nil, fmt.Errorf("unterminated range of field %s", field)
+	}
+	closing := upper[len(upper)-1]
+	upper = upper[:len(upper)-1]
+
+	r := queryRange{filter: RangeFilter{Field: field}}
+	if lower != "*" {
+		if token[0] == '[' {
+			r.filter.Gte = rangeBound(lower)
+		} else {
+			r.filter.Gt = rangeBound(lower)
+		}
+	}
+	if upper != "*" {
+		if closing == ']' {
+			r.filter.Lte = rangeBound(upper)
+		} else {
+			r.filter.Lt = rangeBound(upper)
+		}
+	}
+	return r, nil
+}
+
+func newQueryTerm(field string, token string) (queryNode, error) {
+	if token == "*" {
+		return queryExists{field: field}, nil
+	}
+	for _, operator := range []string{">=", "<=", ">", "<"} {
+		if strings.HasPrefix(token, operator) && len(token) > len(operator) {
+			bound := rangeBound(token[len(operator):])
+			r := queryRange{filter: RangeFilter{Field: field}}
+			switch operator {
+			case ">=":
+				r.filter.Gte = bound
+			case "<=":
+				r.filter.Lte = bound
+			case ">":
+				r.filter.Gt = bound
+			case "<":
+				r.filter.Lt = bound
+			}
+			return r, nil
+		}
+	}
+	if len(token) > 1 && strings.HasPrefix(token, "/") && strings.HasSuffix(token, "/") {
+		re, err := regexp.Compile("(?i)^(?:" + token[1:len(token)-1] + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %s", token)
+		}
+		return queryRegexp{field: field, regexp: re}, nil
+	}
+
+	term := queryTermSuffixRegexp.ReplaceAllString(token, "")
+	re := searchTermsRegexp([]string{term})
+	if term == "" || re == nil {
+		return nil, fmt.Errorf("empty term %s", token)
+	}
+	return queryTerm{field: field, regexp: re}, nil
+}
+
+// Numeric bounds are compared as numbers, other bounds as timestamps or strings
+func rangeBound(bound string) interface{} {
+	if number, ok := parseFloat(bound); ok {
+		return number
+	}
+	return strings.Trim(bound, "\"")
+}
+
+func (queryAll) matches(entry map[string]interface{}) bool {
+	return true
+}
+
+func (q queryAnd) matches(entry map[string]interface{}) bool {
+	for _, node := range q {
+		if !node.matches(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q queryOr) matches(entry map[string]interface{}) bool {
+	for _, node := range q {
+		if node.matches(entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q queryNot) matches(entry map[string]interface{}) bool {
+	return !q.node.matches(entry)
+}
+
+func (q queryTerm) matches(entry map[string]interface{}) bool {
+	return anyFieldValue(entry, q.field, q.regexp.MatchString)
+}
+
+// Like the regexp query, the expression has to match a whole token or the whole value
+func (q queryRegexp) matches(entry map[string]interface{}) bool {
+	return anyFieldValue(entry, q.field, func(value string) bool {
+		if q.regexp.MatchString(value) {
+			return true
+		}
+		for _, token := range strings.FieldsFunc(value, func(r rune) bool { return !isWordRune(r) }) {
+			if q.regexp.MatchString(token) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (q queryExists) matches(entry map[string]interface{}) bool {
+	_, ok := lookupField(entry, q.field)
+	return ok
+}
+
+func (q queryRange) matches(entry map[string]interface{}) bool {
+	return filterMatches(q.filter, entry)
+}
+
+// Like anyFieldElement, but match is called for the value formatted as string
+func anyFieldValue(entry map[string]interface{}, field string, match func(string) bool) bool {
+	return anyFieldElement(entry, field, func(value interface{}) bool {
+		return match(fieldValue(map[string]interface{}{"v": value}, "v"))
+	})
+}
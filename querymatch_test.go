@@ -0,0 +1,208 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func testEntry() map[string]interface{} {
+	return map[string]interface{}{
+		"@timestamp": "2024-01-02T10:00:00.123Z",
+		"message":    "Connection timeout to db-1 after 30s",
+		"level":      "ERROR",
+		"host":       "web-2",
+		"status":     503.0,
+		"tags":       []interface{}{"prod", "eu-west"},
+		"codes":      []interface{}{200.0, 404.0},
+		"request":    map[string]interface{}{"path": "/api/users", "ms": "120"},
+		"user":       nil,
+	}
+}
+
+func TestQueryStringMatches(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	cases := []struct {
+		query   string
+		matches bool
+	}{
+		{"", true},
+		{"timeout", true},
+		{"TimeOut", true},
+		{"time", false},
+		{"db", true},
+		//boolean operators, terms without operator must all match
+		{"timeout refused", false},
+		{"timeout db", true},
+		{"timeout AND refused", false},
+		{"timeout && db", true},
+		{"timeout OR refused", true},
+		{"refused || timeout", true},
+		{"refused OR denied", false},
+		{"refused OR timeout AND db", true},
+		{"(refused OR timeout) AND level:warn", false},
+		{"(refused OR timeout) AND level:error", true},
+		//negation
+		{"NOT timeout", false},
+		{"NOT refused", true},
+		{"-timeout", false},
+		{"!refused", true},
+		{"timeout -level:error", false},
+		{"timeout -level:warn", true},
+		{"timeout NOT (level:error OR level:warn)", false},
+		{"+timeout +db", true},
+		//phrases and field prefixes
+		{`"connection timeout"`, true},
+		{`"timeout connection"`, false},
+		{`"timeout to db"`, true},
+		{`message:"after 30s"`, true},
+		{"level:error", true},
+		{"level:warn", false},
+		{"host:web", true},
+		{"host:web-2", true},
+		{"message:web", false},
+		{"request.path:users", true},
+		{"level:(warn OR error)", true},
+		{"level:(warn OR info)", false},
+		{"timeout~2", true},
+		{`"connection timeout"^3`, true},
+		//ranges
+		{"status:[500 TO 599]", true},
+		{"status:[400 TO 499]", false},
+		{"status:[503 TO 503]", true},
+		{"status:{503 TO 599]", false},
+		{"status:{502 TO *}", true},
+		{"status:{503 TO *}", false},
+		{"status:[* TO 503}", false},
+		{"status:[* TO 503]", true},
+		{"status:[ 500 TO 599 ]", true},
+		{"request.ms:[100 TO 200]", true},
+		{"@timestamp:[2024-01-02T00:00:00Z TO *]", true},
+		{"@timestamp:{2024-01-02T10:00:00.123Z TO *}", false},
+		{"@timestamp:[* TO 2024-01-02T11:00:00.123+01:00]", true},
+		{"missing:[0 TO *]", false},
+		//comparisons
+		{"status:>=503", true},
+		{"status:>503", false},
+		{"status:<600", true},
+		{"status:<=502", false},
+		{"request.ms:>100", true},
+		{"request.ms:>1000", false},
+		//existence
+		{"_exists_:host", true},
+		{"_exists_:request.path", true},
+		{"_exists_:missing", false},
+		{"_exists_:user", false},
+		{"NOT _exists_:missing", true},
+		{"host:*", true},
+		{"missing:*", false},
+		//wildcards and regular expressions
+		{"time*", true},
+		{"timeo?t", true},
+		{"tim?", false},
+		{"*out", true},
+		{"host:we*", true},
+		{"/time.*/", true},
+		{"/time/", false},
+		{"message:/conn[a-z]+/", true},
+		{"host:/web-[0-9]/", true},
+		//array fields match if any element matches
+		{"tags:prod", true},
+		{"tags:eu", true},
+		{"tags:us", false},
+		{"tags:prod AND tags:eu-west", true},
+		{`tags:"eu west"`, true},
+		{"codes:404", true},
+		{"codes:[400 TO 499]", true},
+		{"codes:>=500", false},
+		{"tags:*", true},
+	}
+	for _, c := range cases {
+		node, err := parseQueryString(c.query)
+		if err != nil {
+			t.Errorf("%s: %s", c.query, err)
+			continue
+		}
+		if matches := node.matches(testEntry()); matches != c.matches {
+			t.Errorf("%s: expected match %t, got %t", c.query, c.matches, matches)
+		}
+	}
+}
+
+func TestQueryStringErrors(t *testing.T) {
+	for _, query := range []string{
+		"(timeout",
+		"timeout)",
+		"AND timeout",
+		"timeout OR",
+		"NOT",
+		"level:",
+		"status:[500 599]",
+		"status:[500 TO 599",
+		"status:[500 TO",
+		"/[/",
+		"~2",
+	} {
+		if _, err := parseQueryString(query); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
+
+func TestQueryTermKinds(t *testing.T) {
+	cases := []struct {
+		token    string
+		expected queryNode
+	}{
+		{"*", queryExists{field: "status"}},
+		{">=500", queryRange{filter: RangeFilter{Field: "status", Gte: 500.0}}},
+		{"<=500", queryRange{filter: RangeFilter{Field: "status", Lte: 500.0}}},
+		{">500", queryRange{filter: RangeFilter{Field: "status", Gt: 500.0}}},
+		{"<2024-01-02", queryRange{filter: RangeFilter{Field: "status", Lt: "2024-01-02"}}},
+		{">", nil},
+		{"/5[0-9]+/", nil},
+		{"503", nil},
+	}
+	for _, c := range cases {
+		node, err := newQueryTerm("status", c.token)
+		if err != nil {
+			t.Errorf("%s: %s", c.token, err)
+			continue
+		}
+		switch c.token {
+		case "/5[0-9]+/":
+			if _, ok := node.(queryRegexp); !ok {
+				t.Errorf("%s: expected regexp, got %#v", c.token, node)
+			}
+		case ">", "503":
+			if _, ok := node.(queryTerm); !ok {
+				t.Errorf("%s: expected term, got %#v", c.token, node)
+			}
+		default:
+			if !reflect.DeepEqual(node, c.expected) {
+				t.Errorf("%s: expected %#v, got %#v", c.token, c.expected, node)
+			}
+		}
+	}
+	if _, err := newQueryTerm("status", "^2"); err == nil {
+		t.Errorf("Expected error of term without text")
+	}
+}
+
+// Filters given by -F are applied in addition to the query string
+func TestQueryMatchesEntryWithFilters(t *testing.T) {
+	node, _ := parseQueryString("timeout")
+	cases := []struct {
+		filters []Filter
+		matches bool
+	}{
+		{nil, true},
+		{[]Filter{TermsFilter{Field: "level", Values: []string{"ERROR"}}}, true},
+		{[]Filter{TermsFilter{Field: "level", Values: []string{"ERROR"}}, ExistsFilter{Field: "missing"}}, false},
+	}
+	for _, c := range cases {
+		if matches := (Query{Filters: c.filters}).matchesEntry(node, testEntry()); matches != c.matches {
+			t.Errorf("%v: expected match %t, got %t", c.filters, c.matches, matches)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Percentiles printed by --stats
+var statsPercents = []float64{50, 95, 99}
+
+// Number of buckets aimed for in the distribution of values
+const statsDistributionBuckets = 20
+
+// Maximum number of groups when grouped by a field
+const statsGroups = 10
+
+// Prints statistics (count, min, avg, percentiles and max) of the numeric field given using --stats among entries
+// matching the query, followed by distribution of the values. If grouped by a field, statistics of the most frequent
+// values of that field are printed as a table.
+func (t *Tail) FieldStats() {
+	field := t.queryDefinition.StatsField
+	aggregations := statsAggregations(field)
+	if t.queryDefinition.StatsGroupBy != "" {
+		aggregations["group"] = TermsAggregation{Field: t.queryDefinition.StatsGroupBy, Size: statsGroups,
+			SubAggregations: statsAggregations(field)}
+	}
+	result, err := t.backend.Aggregate(&SearchRequest{
+		Indices:      t.indices,
+		Query:        t.buildSearchQuery(),
+		Aggregations: aggregations,
+	})
+	if err != nil {
+		Error.Fatalln("Error in executing search query.", err)
+	}
+	stats, _ := result.Stats("stats")
+	if stats == nil || stats.Count == 0 || stats.Min == nil || stats.Max == nil {
+		fmt.Fprintln(InfoOutput, paintInfoline("No values of field "+field+" found in matching entries"))
+		return
+	}
+
+	rows := [][]string{statsRow("all", result)}
+	if groups, ok := result.Buckets("group"); ok {
+		for _, group := range groups {
+			rows = append(rows, statsRow(group.KeyString(), group.Aggregations))
+		}
+	}
+	fmt.Println(paintInfoline(fmt.Sprintf("Statistics of %s:", field)))
+	printStatsTable(rows)
+
+	fmt.Println(paintInfoline(fmt.Sprintf("Distribution of %s:", field)))
+	t.printDistribution(field, *stats.Min, *stats.Max)
+}
+
+func statsAggregations(field string) map[string]Aggregation {
+	return map[string]Aggregation{
+		"stats":       StatsAggregation{Field: field},
+		"percentiles": PercentilesAggregation{Field: field, Percents: statsPercents},
+	}
+}
+
+// Returns cells of the table row - name, count, min, avg, percentiles and max
+func statsRow(name string, aggregations AggregationResults) []string {
+	row := []string{name}
+	stats, ok := aggregations.Stats("stats")
+	if !ok {
+		stats = &Stats{}
+	}
+	percentiles, _ := aggregations.Percentiles("percentiles")
+	row = append(row, strconv.FormatInt(stats.Count, 10), formatStatValue(stats.Min), formatStatValue(stats.Avg))
+	for _, percent := range statsPercents {
+		row = append(row, formatStatValue(percentiles[percent]))
+	}
+	return append(row, formatStatValue(stats.Max))
+}
+
+func printStatsTable(rows [][]string) {
+	header := []string{"", "count", "min", "avg"}
+	for _, percent := range statsPercents {
+		header = append(header, "p"+strconv.FormatFloat(percent, 'f', -1, 64))
+	}
+	header = append(header, "max")
+
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	fmt.Println(paintInfoline(statsTableLine(header, widths)))
+	for _, row := range rows {
+		fmt.Println(statsTableLine(row, widths))
+	}
+}
+
+// Name is aligned to the left, numbers to the right
+func statsTableLine(row []string, widths []int) string {
+	cells := make([]string, len(row))
+	for i, cell := range row {
+		if i == 0 {
+			cells[i] = fmt.Sprintf("%-*s", widths[i], cell)
+		} else {
+			cells[i] = fmt.Sprintf("%*s", widths[i], cell)
+		}
+	}
+	return strings.Join(cells, "  ")
+}
+
+// Prints number of values per interval as a bar chart. Interval is chosen so that values between min and max are
+// split into about statsDistributionBuckets buckets.
+func (t *Tail) printDistribution(field string, min float64, max float64) {
+	interval := statsInterval(max - min)
+	lower := math.Floor(min/interval) * interval
+	result, err := t.backend.Aggregate(&SearchRequest{
+		Indices: t.indices,
+		Query:   t.buildSearchQuery(),
+		Aggregations: map[string]Aggregation{
+			"distribution": HistogramAggregation{Field: field, Interval: interval, Min: lower, Max: max},
+		},
+	})
+	if err != nil {
+		Error.Fatalln("Error in executing search query.", err)
+	}
+	buckets, _ := result.Buckets("distribution")
+
+	labels := make([]string, len(buckets))
+	var maxCount int64
+	labelWidth, countWidth := 0, 0
+	for i, bucket := range buckets {
+		from, _ := bucket.Key.(float64)
+		labels[i] = formatStatNumber(from) + " - " + formatStatNumber(from+interval)
+		if len(labels[i]) > labelWidth {
+			labelWidth = len(labels[i])
+		}
+		if bucket.DocCount > maxCount {
+			maxCount = bucket.DocCount
+		}
+	}
+	countWidth = len(strconv.FormatInt(maxCount, 10))
+	barWidth := terminalWidth() - labelWidth - countWidth - 3
+	if barWidth < 10 {
+		barWidth = 10
+	}
+	for i, bucket := range buckets {
+		bar := ""
+		if maxCount > 0 {
+			bar = renderBar(float64(bucket.DocCount) / float64(maxCount) * float64(barWidth))
+		}
+		fmt.Printf("%*s %*d %s\n", labelWidth, labels[i], countWidth, bucket.DocCount, bar)
+	}
+}
+
+// Picks a round interval (1, 2 or 5 times power of ten) splitting the range into at most statsDistributionBuckets
+func statsInterval(valueRange float64) float64 {
+	if valueRange <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(valueRange/statsDistributionBuckets)))
+	for _, step := range []float64{1, 2, 5, 10} {
+		if valueRange/(step*magnitude) <= statsDistributionBuckets {
+			return step * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+func formatStatValue(value *float64) string {
+	if value == nil {
+		return "-"
+	}
+	return formatStatNumber(*value)
+}
+
+// Numbers are rounded to two decimal places and never written in exponent notation
+func formatStatNumber(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
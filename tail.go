@@ -1,114 +1,196 @@
 package main
 
 import (
-	"gopkg.in/olivere/elastic.v2"
 	"strings"
 	"time"
 	"fmt"
 	"encoding/json"
-	"regexp"
 )
 
+// Number of entries fetched per page while following the cursor in tail mode
+const tailPageSize = 1000
+
+// How often the tailer re-resolves indices, so that it follows newly created daily indices
+const indicesRefreshInterval = 1 * time.Minute
+
+// How often the tailer re-resolves indices while it is not getting any new entries
+const indicesRefreshOnEmptyInterval = 10 * time.Second
+
 //
 // Structure that holds data necessary to perform tailing.
 //
 type Tail struct {
-	client          *elastic.Client  //elastic search client that we'll use to contact EL
+	backend         LogBackend       //backend storing the logs that we'll use to contact EL
 	queryDefinition *QueryDefinition //structure containing query definition and formatting
 	indices         []string         //indices to search through
-	lastTimeStamp   string           //timestamp of the last result
+	cursor          *tailCursor      //position of the last result in the log stream
 	order           bool             //search order - true = ascending (may be reversed in case date-after filtering)
 	tailMode        bool
+	traceMode       bool             //true when reconstructing timeline of a request
+	listenMode      bool             //true when entries are pushed by Logstash, there's no backend then
+	output          entryWriter      //writes entries in configured output format
+	watcher         *watcher         //takes watch actions on matching entries, nil if there are no watch rules
+	alerts          *alertEvaluator  //evaluates alert rules in tail mode, nil if there are no alert rules
+	highlighter     *highlighter     //highlights search terms and watch rules in printed fields
+	indexPattern    string           //pattern of indices that are followed in tail mode
+	indexNaming     string           //name of the index naming scheme
+	indicesRefresh  time.Time        //time when indices were last resolved
+	clusterNaming   *clusterNamingScheme //time spans of indices resolved by the cluster naming scheme, kept between refreshes
 }
 
 // Selects appropriate indices in EL based on configuration. This basically means that if query is date filtered,
-// then it attempts to select indices in the filtered date range, otherwise it selects the last index.
+// then it attempts to select indices in the filtered date range, otherwise it selects the last index. Time range
+// covered by each index is determined by the index naming scheme of the profile.
 func (tail *Tail) selectIndices(configuration *Configuration) {
-	indices, err := tail.client.IndexNames()
+	indices, err := tail.backend.IndexNames()
 	if err != nil {
 		Error.Fatalln("Could not fetch available indices.", err)
 	}
+	tail.indexPattern = configuration.SearchTarget.IndexPattern
+	tail.indexNaming = configuration.SearchTarget.IndexNaming
+	scheme := tail.indexNamingScheme(indices)
 
 	if configuration.QueryDefinition.IsDateTimeFiltered()  {
 		if configuration.QueryDefinition.Duration != "" && configuration.QueryDefinition.AfterDateTime == "" && configuration.QueryDefinition.BeforeDateTime == "" {
 			configuration.QueryDefinition.DurationSpecified = true
 			configuration.QueryDefinition.SetDurationAsAfterDateTime()
 		}
-		startDate := configuration.QueryDefinition.AfterDateTime
-		endDate := configuration.QueryDefinition.BeforeDateTime
-		if startDate == "" && endDate != "" {
-			lastIndex := findLastIndex(indices, configuration.SearchTarget.IndexPattern)
-			lastIndexDate := extractYMDDate(lastIndex, ".")
-			if lastIndexDate.Before(extractYMDDate(endDate, "-")) {
-				startDate = lastIndexDate.Format(dateFormatDMY)
+		var startDate, endDate time.Time
+		if configuration.QueryDefinition.AfterDateTime != "" {
+			startDate = parseUTCTimestamp(configuration.QueryDefinition.AfterDateTimeInUTC(), configuration.QueryDefinition.AfterDateTime)
+		}
+		if configuration.QueryDefinition.BeforeDateTime != "" {
+			endDate = parseUTCTimestamp(configuration.QueryDefinition.BeforeDateTimeInUTC(), configuration.QueryDefinition.BeforeDateTime)
+		} else {
+			endDate = time.Now().UTC()
+		}
+		if configuration.QueryDefinition.AfterDateTime == "" && configuration.QueryDefinition.BeforeDateTime != "" {
+			lastIndexDate := indexStartTime(findLastIndex(indices, tail.indexPattern, scheme), scheme)
+			if !lastIndexDate.IsZero() && lastIndexDate.Before(endDate) {
+				startDate = lastIndexDate
 			} else {
 				startDate = endDate
 			}
 		}
-		if endDate == "" {
-			endDate = time.Now().Format(dateFormatDMY)
-		}
-		tail.indices = findIndicesForDateRange(indices, configuration.SearchTarget.IndexPattern, startDate, endDate)
+		tail.indices = findIndicesForDateRange(indices, tail.indexPattern, scheme, startDate, endDate)
 
+	} else if (tail.traceMode && configuration.QueryDefinition.TraceWindow == TraceWindowAll) || configuration.isOffline() {
+		//local files are all searched, there's no point in picking the last one
+		tail.indices = matchingIndices(indices, tail.indexPattern)
 	} else {
-		index := findLastIndex(indices, configuration.SearchTarget.IndexPattern)
+		index := findLastIndex(indices, tail.indexPattern, scheme)
 		result := [...]string{index}
 		tail.indices = result[:]
 	}
+	tail.indicesRefresh = time.Now()
 	Info.Printf("Using indices: %s", tail.indices)
 }
 
+// Creates the index naming scheme configured for the profile
+func (t *Tail) indexNamingScheme(indices []string) IndexNamingScheme {
+	if t.clusterNaming != nil {
+		err := t.clusterNaming.refresh(t.backend, matchingIndices(indices, t.indexPattern), t.queryDefinition.TimestampField, time.Now())
+		if err != nil {
+			Error.Fatalln("Could not resolve index naming scheme.", err)
+		}
+		return t.clusterNaming
+	}
+	scheme, err := NewIndexNamingScheme(t.indexNaming, t.backend, indices, t.indexPattern, t.queryDefinition.TimestampField)
+	if err != nil {
+		Error.Fatalln("Could not resolve index naming scheme.", err)
+	}
+	if cluster, ok := scheme.(*clusterNamingScheme); ok {
+		t.clusterNaming = cluster
+	}
+	return scheme
+}
+
+// Re-resolves indices while tailing. Newly created indices matching the index pattern are added and the ones that
+// end before the last seen entry are dropped. Current indices are kept if resolution fails or finds nothing.
+func (t *Tail) refreshIndices() {
+	t.indicesRefresh = time.Now()
+	indices, err := t.backend.IndexNames()
+	if err != nil {
+		Error.Println("Could not refresh available indices.", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	start := now
+	if !t.cursor.isEmpty() {
+		if lastEntryTime, err := time.Parse(time.RFC3339Nano, t.cursor.timestamp); err == nil && lastEntryTime.Before(now) {
+			start = lastEntryTime.UTC()
+		}
+	}
+	refreshed := findIndicesForDateRange(indices, t.indexPattern, t.indexNamingScheme(indices), start, now)
+	if len(refreshed) == 0 {
+		Trace.Printf("No indices found for the active window, keeping indices: %s", t.indices)
+		return
+	}
+	if strings.Join(refreshed, ",") != strings.Join(t.indices, ",") {
+		Info.Printf("Switching indices from %s to %s", t.indices, refreshed)
+		t.indices = refreshed
+	}
+}
+
+// Indices are refreshed periodically and more eagerly while the tailer is not getting any new entries.
+func (t *Tail) shouldRefreshIndices(fetched int) bool {
+	sinceRefresh := time.Since(t.indicesRefresh)
+	return sinceRefresh >= indicesRefreshInterval || (fetched == 0 && sinceRefresh >= indicesRefreshOnEmptyInterval)
+}
+
 // Start the tailer
 func (t *Tail) Start(entriesPerBatch int) {
+	var pager *pager
+	if !t.tailMode {
+		pager = newPager(t, entriesPerBatch)
+	}
 	result, err := t.initialSearch(entriesPerBatch)
 	if err != nil {
 		Error.Fatalln("Error in executing search query.", err)
 	}
 	t.processResults(result)
+	if t.watcher != nil {
+		t.watcher.armed = true
+	}
 
 	if (t.tailMode) {
 		t.InfinitelyTail(entriesPerBatch)
 	} else {
-		t.InfinitelyPromptUser(entriesPerBatch)
-	}
-}
-
-func (t *Tail) InfinitelyPromptUser(entriesPerBatch int) {
-	var result *elastic.SearchResult
-	var err error
-	for true {
-		if shouldFetchMoreEntries() {
-			result, err = t.FetchNextBatchOfEntries(entriesPerBatch)
-			if err != nil {
-				Error.Fatalln("Error in executing search query.", err)
-			}
-			t.processResults(result)
-		} else {
-			break
-		}
+		pager.prompt()
 	}
 }
 
 func (t *Tail) InfinitelyTail(entriesPerBatch int) {
-	var result *elastic.SearchResult
+	var fetched int
 	var err error
 	delay := 500 * time.Millisecond
 	for true {
 		time.Sleep(delay)
-		if t.lastTimeStamp != "" {
-			//we can execute follow up timestamp filtered query only if we fetched at least 1 result in initial query
-			result, err = t.FetchNextBatchOfEntries(9000) //TODO: needs rewrite this using scrolling, as this implementation may loose entries if there's more than 9K entries per sleep period
+		if !t.cursor.isEmpty() {
+			//we can execute follow up cursor queries only if we fetched at least 1 result in initial query
+			fetched, err = t.drainNewEntries(tailPageSize, 0)
 		} else {
-			//if lastTimeStamp is not defined we have to repeat the initial search until we get at least 1 result
+			//if cursor is empty we have to repeat the initial search until we get at least 1 result
+			var result *SearchResponse
 			result, err = t.initialSearch(entriesPerBatch)
+			if err == nil {
+				fetched = t.processResults(result)
+			}
 		}
 		if err != nil {
 			Error.Fatalln("Error in executing search query.", err)
 		}
-		t.processResults(result)
+		if t.alerts != nil {
+			t.alerts.evaluate(time.Now())
+		}
+
+		if t.shouldRefreshIndices(fetched) {
+			t.refreshIndices()
+		}
 
 		//Dynamic delay calculation for determining delay between search requests
-		if result.TotalHits() > 0 && delay > 500 * time.Millisecond {
+		if fetched > 0 && delay > 500 * time.Millisecond {
 			delay = 500 * time.Millisecond
 		} else if delay <= 2000 * time.Millisecond {
 			delay = delay + 500 * time.Millisecond
@@ -116,113 +198,184 @@ func (t *Tail) InfinitelyTail(entriesPerBatch int) {
 	}
 }
 
-func (t *Tail) FetchNextBatchOfEntries(entriesPerBatch int) (*elastic.SearchResult, error) {
-	return t.client.Search().
-		Indices(t.indices...).
-		Sort(t.queryDefinition.TimestampField, false).
-		From(0).
-		Size(entriesPerBatch).
-		Query(t.buildTimestampFilteredQuery()).
-		Do()
+// Fetches and processes pages of entries following the cursor until there are no more entries available or the
+// limit is reached (0 means no limit). Returns the number of new entries that were processed.
+func (t *Tail) drainNewEntries(pageSize int, limit int) (int, error) {
+	return t.followCursor(pageSize, limit, t.processResults)
+}
+
+// Fetches pages of entries following the cursor and hands them to process, which returns the number of new entries
+// in the page, until there are no more entries available or at least limit new entries were processed (0 means no
+// limit). Each page continues after the last hit of the previous one (search_after). Backends that don't report sort
+// values of hits, or whose tiebreaker isn't unique, skip over entries sharing the boundary timestamp using offset instead.
+func (t *Tail) followCursor(pageSize int, limit int, process func(*SearchResponse) int) (int, error) {
+	total := 0
+	offset := 0
+	var after []interface{}
+	for {
+		boundary := t.cursor.timestamp
+		result, err := t.FetchNextBatchOfEntries(offset, after, pageSize)
+		if err != nil {
+			return total, err
+		}
+		total += process(result)
+		hits := len(result.Hits)
+		if hits < pageSize || (limit > 0 && total >= limit) {
+			return total, nil
+		}
+		after = result.Hits[hits-1].Sort
+		if t.cursor.timestamp == boundary {
+			//whole page shares the boundary timestamp, we need to skip over it to make progress
+			offset += hits
+		} else {
+			offset = 0
+		}
+	}
+}
+
+// Fetches the page of entries following the cursor. After (sort values of the last hit of the previous page) or
+// offset are only needed when more than a page of entries shares the boundary timestamp.
+func (t *Tail) FetchNextBatchOfEntries(offset int, after []interface{}, entriesPerBatch int) (*SearchResponse, error) {
+	request := &SearchRequest{
+		Indices:     t.indices,
+		Query:       t.buildSearchQuery(),
+		From:        offset,
+		SearchAfter: after,
+		Size:        entriesPerBatch,
+		Highlight:   t.queryDefinition.ServerHighlight,
+	}
+	return t.backend.TailPage(request, t.queryDefinition.TimestampField, t.cursor)
 }
 
 // Initial search needs to be run until we get at least one result
 // in order to fetch the timestamp which we will use in subsequent follow searches
-func (t *Tail) initialSearch(entriesPerBatch int) (*elastic.SearchResult, error) {
-	return t.client.Search().
-		Indices(t.indices...).
-		Sort(t.queryDefinition.TimestampField, t.order).
-		Query(t.buildSearchQuery()).
-		From(0).Size(entriesPerBatch).
-		Do()
+func (t *Tail) initialSearch(entriesPerBatch int) (*SearchResponse, error) {
+	return t.backend.Search(t.initialSearchRequest(entriesPerBatch))
 }
 
-func (t *Tail) ListAllSources() (*elastic.SearchResult, error) {
-	tagg := elastic.NewTermsAggregation().Field("source").Size(100).Order("_term", true)
-	return t.client.Search().
-		Indices(t.indices...).
-		Aggregation("source", tagg).
-		Size(0).
-		Do()
+func (t *Tail) initialSearchRequest(entriesPerBatch int) *SearchRequest {
+	return &SearchRequest{
+		Indices:   t.indices,
+		Query:     t.buildSearchQuery(),
+		Sort:      []SortField{{Field: t.queryDefinition.TimestampField, Ascending: t.order}},
+		From:      0,
+		Size:      entriesPerBatch,
+		Highlight: t.queryDefinition.ServerHighlight,
+	}
 }
 
-func (t *Tail) processSources(searchResult *elastic.SearchResult) {
-	sources, ok := searchResult.Aggregations.Terms("source")
+func (t *Tail) ListAllSources() (AggregationResults, error) {
+	return t.backend.Aggregate(&SearchRequest{
+		Indices: t.indices,
+		Aggregations: map[string]Aggregation{
+			"source": TermsAggregation{Field: "source", Size: 100, OrderByKey: true},
+		},
+	})
+}
+
+func (t *Tail) processSources(aggregations AggregationResults) {
+	sources, ok := aggregations.Buckets("source")
 	if ok {
-		for _, res := range sources.Buckets {
-			fmt.Println(res.Key)
+		for _, res := range sources {
+			fmt.Println(res.KeyString())
 		}
 	}
 }
 
 
-// Process the results (e.g. prints them out based on configured format)
-func (t *Tail) processResults(searchResult *elastic.SearchResult) {
-	//Trace.Printf("Fetched page of %d results out of %d total.\n", len(searchResult.Hits.Hits), searchResult.Hits.TotalHits)
-	hits := searchResult.Hits.Hits
+// Process the results (e.g. prints them out based on configured format). Entries that were already processed are
+// skipped. Returns the number of newly processed entries.
+func (t *Tail) processResults(searchResult *SearchResponse) int {
+	//Trace.Printf("Fetched page of %d results out of %d total.\n", len(searchResult.Hits), searchResult.TotalHits)
+	hits := searchResult.Hits
+	processed := 0
 
 	if t.order {
 		for i := 0; i < len(hits); i++ {
-			hit := hits[i]
-			entry := t.processHit(hit)
-			t.lastTimeStamp = entry[t.queryDefinition.TimestampField].(string)
+			if t.processHit(hits[i]) {
+				processed++
+			}
 		}
 	} else {
 		//when results are in descending order, we need to process them in reverse
 		for i := len(hits) - 1; i >= 0; i-- {
-			hit := hits[i]
-			entry := t.processHit(hit)
-			t.lastTimeStamp = entry[t.queryDefinition.TimestampField].(string)
+			if t.processHit(hits[i]) {
+				processed++
+			}
 		}
 	}
+	return processed
 }
 
-func (t *Tail) processHit(hit *elastic.SearchHit) map[string]interface{} {
+// Registers the hit with the cursor and prints it out. Returns false if the hit was already seen.
+func (t *Tail) processHit(hit *SearchHit) bool {
 	var entry map[string]interface{}
-	err := json.Unmarshal(*hit.Source, &entry)
+	err := json.Unmarshal(hit.Source, &entry)
 	if err != nil {
 		Error.Fatalln("Failed parsing ElasticSearch response.", err)
 	}
-	t.printResult(entry)
-	return entry
+	timestamp, _ := entry[t.queryDefinition.TimestampField].(string)
+	if !t.cursor.advance(timestamp, hit.Uid()) {
+		return false
+	}
+	t.output.WriteEntry(hit, entry)
+	if t.watcher != nil {
+		t.watcher.process(hit, entry)
+	}
+	if t.alerts != nil {
+		parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			Trace.Printf("Failed parsing timestamp %s, entry is not counted by alert rules", timestamp)
+		} else {
+			t.alerts.observe(parsed, entry)
+		}
+	}
+	return true
 }
 
-// Print result according to format
-func (t *Tail) printResult(entry map[string]interface{}) {
+// Print result according to format. Each field reference is substituted on its own, so that e.g. %source does not
+// clobber %source_host.
+func (t *Tail) printResult(entry map[string]interface{}, matchedParts map[string][]string) {
 	Trace.Println("Result: ", entry)
-	fields := formatRegexp.FindAllString(t.queryDefinition.Format, -1)
-	Trace.Println("Fields: ", fields)
-	result := t.queryDefinition.Format
-	for _, f := range fields {
-		value, err := EvaluateExpression(entry, f[1:])
-		if f == "%@timestamp" {
-			parsedTime, timeErr := time.Parse(time.RFC3339, value)
-			if timeErr == nil {
-				formattedTime := parsedTime.In(localTz).Format(time.RFC3339Nano)
-				value = paintTimestamp(formattedTime)
-			} else {
-				Trace.Println("parsing error: ", timeErr)
-			}
-		} else if f == "%x_request_id" && len(value) > 0 {
-			value = paintRequestId(value)
-		} else if f == "%source" && len(value) > 0 {
-			value = paintSource(value)
-		} else if f == "%message" && len(value) > 0 && (len(t.queryDefinition.Terms) > 0 || t.queryDefinition.Watch != "") {
-			if len(t.queryDefinition.Terms) > 0 {
-				toHighlight := strings.Join(t.queryDefinition.Terms, " ")
-				value = strings.Replace(value, toHighlight, highlightContent(toHighlight), -1)
-			} else if t.queryDefinition.Watch != "" {
-				value = strings.Replace(value, t.queryDefinition.Watch, highlightContent(t.queryDefinition.Watch), -1)
-			}
-		}
+	fmt.Println(t.formatEntry(entry, matchedParts))
+}
+
+// Formats the entry according to format. Matched parts are parts of fields reported by the backend as matching the
+// query, keyed by field (may be nil).
+func (t *Tail) formatEntry(entry map[string]interface{}, matchedParts map[string][]string) string {
+	return formatRegexp.ReplaceAllStringFunc(t.queryDefinition.Format, func(f string) string {
+		return t.formatField(entry, f, matchedParts)
+	})
+}
 
-		if err == nil {
-			result = strings.Replace(result, f, value, -1)
+// Evaluates and paints the field reference (e.g. %message) of the format
+func (t *Tail) formatField(entry map[string]interface{}, f string, matchedParts map[string][]string) string {
+	value, err := EvaluateExpression(entry, f[1:])
+	if err != nil {
+		return "" //the field might not be available in the results
+	}
+	var paint func(string) string
+	if f == "%@timestamp" {
+		parsedTime, timeErr := time.Parse(time.RFC3339, value)
+		if timeErr == nil {
+			value = padTimestamp(parsedTime.In(localTz).Format(time.RFC3339Nano))
+			paint = paintPaddedTimestamp
 		} else {
-			result = strings.Replace(result, f, "", -1) //the field might not be available in the results
+			Trace.Println("parsing error: ", timeErr)
 		}
+	} else if f == "%x_request_id" && len(value) > 0 {
+		paint = paintRequestId
+	} else if f == "%source" && len(value) > 0 {
+		paint = paintSource
 	}
-	fmt.Println(result)
+	//search terms and watch rules are highlighted in any field, the rest of the field keeps its own color
+	if len(value) > 0 && t.highlighter != nil {
+		return t.highlighter.highlightPainted(value, matchedParts[f[1:]], paint)
+	}
+	if paint != nil {
+		value = paint(value)
+	}
+	return value
 }
 
 func rightPad2Len(s string, padStr string, overallLen int) string {
@@ -232,10 +385,8 @@ func rightPad2Len(s string, padStr string, overallLen int) string {
 	return retStr[:overallLen]
 }
 
-func (t *Tail) buildSearchQuery() elastic.Query {
-	var query elastic.Query
-
-	elastic.SetTraceLog(Trace)
+func (t *Tail) buildSearchQuery() Query {
+	var query Query
 
 	if len(t.queryDefinition.Terms) > 0 {
 		queryTerms := []string{}
@@ -245,95 +396,80 @@ func (t *Tail) buildSearchQuery() elastic.Query {
 		if len(queryTerms) > 0 {
 			result := strings.Join(queryTerms, " ")
 			Info.Printf("Filtering by keyword %s", result)
-			query = elastic.NewQueryStringQuery(result).DefaultField("message").DefaultOperator("and")
+			query.QueryString = result
 		}
 	} else {
 		Info.Print("Filtering by no keywords...")
-		query = elastic.NewMatchAllQuery()
 	}
 
 	if (t.queryDefinition.isSourceFiltered()) {
 		sources := strings.Split(t.queryDefinition.Source, ",")
 		Info.Printf("Adding source filter %s", sources)
-		query = elastic.NewFilteredQuery(query).Filter(elastic.NewTermsFilter("source", sources))
+		query.Filters = append(query.Filters, TermsFilter{Field: "source", Values: sources})
+	}
+
+	fieldFilters, err := t.queryDefinition.FieldFilters()
+	if err != nil {
+		Error.Fatalln(err)
+	}
+	if len(fieldFilters) > 0 {
+		Info.Printf("Adding field filters %s", t.queryDefinition.Filters)
+		query.Filters = append(query.Filters, fieldFilters...)
 	}
 
 	if (t.queryDefinition.isRequestIdFiltered()) {
 		Info.Printf("Adding x_request_id filter %s", t.queryDefinition.RequestId)
-		query = elastic.NewFilteredQuery(query).Filter(elastic.NewTermFilter("x_request_id", t.queryDefinition.RequestId[0:8]))
+		if t.traceMode || t.evaluatesLocally() {
+			//trace looks for the full request id, as well as local matching where ids are not split into tokens
+			query.Filters = append(query.Filters, MatchPhraseFilter{Field: "x_request_id", Phrase: t.queryDefinition.RequestId})
+		} else {
+			//analyzed request ids are split on dashes, so the first token of uuid is enough
+			query.Filters = append(query.Filters, TermsFilter{Field: "x_request_id", Values: []string{requestIdPrefix(t.queryDefinition.RequestId)}})
+		}
 	}
 
 	if t.queryDefinition.IsDateTimeFiltered() {
 		// we have date filtering turned on, apply filter
-		filter := t.buildDateTimeRangeFilter()
-		query = elastic.NewFilteredQuery(query).Filter(filter)
+		query.Filters = append(query.Filters, t.buildDateTimeRangeFilter())
 	}
 
 	return query
 }
 
+// Returns true if queries are evaluated on the client side (local files or listener) rather than by a cluster
+func (t *Tail) evaluatesLocally() bool {
+	_, local := t.backend.(*localBackend)
+	return local || t.listenMode
+}
+
+func requestIdPrefix(requestId string) string {
+	if len(requestId) > 8 {
+		return requestId[0:8]
+	}
+	return requestId
+}
+
 //Builds range filter on timestamp field. You should only call this if start or end date times are defined
 //in query definition
-func (t *Tail) buildDateTimeRangeFilter() elastic.RangeFilter {
-	filter := elastic.NewRangeFilter(t.queryDefinition.TimestampField)
+func (t *Tail) buildDateTimeRangeFilter() RangeFilter {
+	filter := RangeFilter{Field: t.queryDefinition.TimestampField}
 
 	if t.queryDefinition.Duration != "" && t.queryDefinition.BeforeDateTime == "" {
 		Trace.Printf("Duration query - entries for the past %s", t.queryDefinition.Duration)
-		if t.lastTimeStamp == "" && t.queryDefinition.DurationSpecified {
-			fmt.Println(paintInfoline("Querying logs after " + t.queryDefinition.AfterDateTime + ". Duration filter: " + t.queryDefinition.Duration))
+		if t.cursor.isEmpty() && t.queryDefinition.DurationSpecified {
+			fmt.Fprintln(InfoOutput, paintInfoline("Querying logs after " + t.queryDefinition.AfterDateTime + ". Duration filter: " + t.queryDefinition.Duration))
 		}
 		t.queryDefinition.SetDurationAsAfterDateTime()
 	}
 
 	if t.queryDefinition.AfterDateTime != "" {
 		Trace.Printf("Date range query - timestamp after: %s", t.queryDefinition.AfterDateTimeInUTC())
-		filter = filter.IncludeLower(true).
-			From(t.queryDefinition.AfterDateTimeInUTC())
+		filter.Gte = t.queryDefinition.AfterDateTimeInUTC()
 	}
 	if t.queryDefinition.BeforeDateTime != "" {
 		Trace.Printf("Date range query - timestamp before: %s", t.queryDefinition.BeforeDateTimeInUTC())
-		filter = filter.IncludeUpper(false).
-			To(t.queryDefinition.BeforeDateTimeInUTC())
+		filter.Lt = t.queryDefinition.BeforeDateTimeInUTC()
 	}
 
 	return filter
 }
-
-func (t *Tail) buildTimestampFilteredQuery() elastic.Query {
-	query := elastic.NewFilteredQuery(t.buildSearchQuery()).Filter(
-		elastic.NewRangeFilter(t.queryDefinition.TimestampField).
-			IncludeUpper(false).
-			Gt(t.lastTimeStamp))
-	return query
-}
-
-func findIndicesForDateRange(indices []string, indexPattern string, startDate string, endDate string) []string {
-	start := extractYMDDate(startDate, "-")
-	end := extractYMDDate(endDate, "-")
-	result := make([]string, 0, len(indices))
-	for _, idx := range indices {
-		matched, _ := regexp.MatchString(indexPattern, idx)
-		if matched {
-			idxDate := extractYMDDate(idx, ".")
-			if (idxDate.After(start) || idxDate.Equal(start)) && (idxDate.Before(end) || idxDate.Equal(end)) {
-				result = append(result, idx)
-			}
-		}
-	}
-	return result
-}
-
-func findLastIndex(indices []string, indexPattern string) string {
-	var lastIdx string
-	for _, idx := range indices {
-		matched, _ := regexp.MatchString(indexPattern, idx)
-		if matched {
-			if &lastIdx == nil {
-				lastIdx = idx
-			} else if idx > lastIdx {
-				lastIdx = idx
-			}
-		}
-	}
-	return lastIdx
-}
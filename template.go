@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Prefix of --template value that refers to a named template stored in the profile
+const namedTemplatePrefix = "@"
+
+// Functions available in templates:
+//   field . "a.b"            value of the field using dot syntax, empty if missing
+//   pad N value              pads value with spaces to N characters (negative N aligns to the right)
+//   trunc N value            truncates value to N characters
+//   default fallback value   fallback if value is empty
+//   upper value, lower value changes case of the value
+//   json value               value encoded as JSON
+//   localtime value          timestamp converted to local timezone
+//   color name value         paints value (black, red, green, yellow, blue, magenta, cyan or white)
+//   duration unit value      formats number of units (ns, us, ms, s, m, h) as duration, e.g. 1.5s
+var templateFuncs = template.FuncMap{
+	"field": func(entry map[string]interface{}, field string) string {
+		value, _ := EvaluateExpression(entry, field)
+		return value
+	},
+	"pad": func(width int, value interface{}) string {
+		s := templateString(value)
+		if width < 0 {
+			return fmt.Sprintf("%*s", -width, s)
+		}
+		return fmt.Sprintf("%-*s", width, s)
+	},
+	"trunc": func(length int, value interface{}) string {
+		runes := []rune(templateString(value))
+		if length >= 0 && len(runes) > length {
+			return string(runes[:length])
+		}
+		return string(runes)
+	},
+	"default": func(fallback interface{}, value interface{}) interface{} {
+		if templateString(value) == "" {
+			return fallback
+		}
+		return value
+	},
+	"upper": func(value interface{}) string {
+		return strings.ToUpper(templateString(value))
+	},
+	"lower": func(value interface{}) string {
+		return strings.ToLower(templateString(value))
+	},
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+	"localtime": func(value interface{}) string {
+		s := templateString(value)
+		parsed, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return s
+		}
+		return parsed.In(localTz).Format(time.RFC3339Nano)
+	},
+	"color": func(name string, value interface{}) (string, error) {
+		attribute, ok := templateColors[name]
+		if !ok {
+			return "", fmt.Errorf("unknown color %s", name)
+		}
+		return color.New(attribute).SprintFunc()(templateString(value)), nil
+	},
+	"duration": func(unit string, value interface{}) (string, error) {
+		multiplier, ok := templateDurationUnits[unit]
+		if !ok {
+			return "", fmt.Errorf("unknown duration unit %s", unit)
+		}
+		s := templateString(value)
+		if s == "" {
+			return "", nil
+		}
+		number, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return s, nil
+		}
+		return time.Duration(number * float64(multiplier)).String(), nil
+	},
+}
+
+var templateColors = map[string]color.Attribute{
+	"black":   color.FgBlack,
+	"red":     color.FgRed,
+	"green":   color.FgGreen,
+	"yellow":  color.FgYellow,
+	"blue":    color.FgBlue,
+	"magenta": color.FgMagenta,
+	"cyan":    color.FgCyan,
+	"white":   color.FgWhite,
+}
+
+var templateDurationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+func templateString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// Resolves template given using --template option. Values starting with @ refer to templates stored in the profile.
+func resolveTemplate(templateText string, namedTemplates map[string]string) (string, error) {
+	if !strings.HasPrefix(templateText, namedTemplatePrefix) {
+		return templateText, nil
+	}
+	name := templateText[len(namedTemplatePrefix):]
+	named, ok := namedTemplates[name]
+	if !ok {
+		return "", fmt.Errorf("Template %s is not stored in the profile", name)
+	}
+	return named, nil
+}
+
+//
+// Writes entries using Go text/template. The entry map is the data of the template, so top level fields are
+// available as {{.message}} and any field as {{field . "@timestamp"}}. Fields referenced as {{.a.b}} that are missing
+// in the entry are printed empty.
+//
+type templateEntryWriter struct {
+	template *template.Template
+	fields   [][]string //paths of fields referenced by the template, e.g. [kubernetes pod] for {{.kubernetes.pod}}
+}
+
+func newTemplateEntryWriter(templateText string) (*templateEntryWriter, error) {
+	if !strings.HasSuffix(templateText, "\n") {
+		templateText += "\n"
+	}
+	parsed, err := template.New("entry").Funcs(templateFuncs).Option("missingkey=zero").Parse(templateText)
+	if err != nil {
+		return nil, err
+	}
+	return &templateEntryWriter{template: parsed, fields: templateFieldPaths(parsed.Tree.Root, nil)}, nil
+}
+
+func (w *templateEntryWriter) WriteEntry(hit *SearchHit, entry map[string]interface{}) {
+	if err := w.template.Execute(os.Stdout, w.withMissingFields(entry)); err != nil {
+		Error.Println("Failed to execute template.", err)
+	}
+}
+
+// Collects paths of fields referenced relative to the entry. Fields within range and with are relative to another
+// value, so they are left out, as well as values ranged over.
+func templateFieldPaths(node parse.Node, paths [][]string) [][]string {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			for _, child := range n.Nodes {
+				paths = templateFieldPaths(child, paths)
+			}
+		}
+	case *parse.ActionNode:
+		paths = templateFieldPaths(n.Pipe, paths)
+	case *parse.TemplateNode:
+		paths = templateFieldPaths(n.Pipe, paths)
+	case *parse.PipeNode:
+		if n != nil {
+			for _, command := range n.Cmds {
+				paths = templateFieldPaths(command, paths)
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			paths = templateFieldPaths(arg, paths)
+		}
+	case *parse.FieldNode:
+		paths = append(paths, n.Ident)
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			paths = append(paths, n.Ident[1:])
+		}
+	case *parse.IfNode:
+		paths = templateFieldPaths(n.Pipe, paths)
+		paths = templateFieldPaths(n.List, paths)
+		paths = templateFieldPaths(n.ElseList, paths)
+	case *parse.RangeNode:
+		//missing value ranged over is nil, which is an empty range unlike an empty string
+		paths = templateFieldPaths(n.ElseList, paths)
+	case *parse.WithNode:
+		paths = templateFieldPaths(n.Pipe, paths)
+		paths = templateFieldPaths(n.ElseList, paths)
+	}
+	return paths
+}
+
+// Returns the entry with fields referenced by the template that are missing (or null) set to empty string, so that
+// they are printed empty rather than as <no value>. The entry itself is not modified, it is copied when needed.
+func (w *templateEntryWriter) withMissingFields(entry map[string]interface{}) map[string]interface{} {
+	for _, path := range w.fields {
+		entry, _ = withMissingField(entry, path)
+	}
+	return entry
+}
+
+func withMissingField(entry map[string]interface{}, path []string) (map[string]interface{}, bool) {
+	value, ok := entry[path[0]]
+	var filled interface{} = ""
+	if len(path) == 1 {
+		if ok && value != nil {
+			return entry, false
+		}
+	} else {
+		nested, isObject := value.(map[string]interface{})
+		if value != nil && !isObject {
+			return entry, false //not an object, the template reports the error
+		}
+		if nested == nil {
+			nested = map[string]interface{}{}
+		}
+		nested, changed := withMissingField(nested, path[1:])
+		if !changed {
+			return entry, false
+		}
+		filled = nested
+	}
+	copied := make(map[string]interface{}, len(entry)+1)
+	for key, value := range entry {
+		copied[key] = value
+	}
+	copied[path[0]] = filled
+	return copied, true
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Units of durations, e.g. 90s, 1h30m, 2w
+var durationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+var durationRegexp = regexp.MustCompile(`^(\d+(?:\.\d+)?)(ms|s|m|h|d|w)`)
+
+// Relative time expressions - now, now-2h, now+30m, today, yesterday 14:00
+var relativeTimeRegexp = regexp.MustCompile(`^(now|today|yesterday)(?:\s*([+-])\s*(\S+)|\s+(\d{1,2}:\d{2}(?::\d{2})?))?$`)
+
+var timeOfDayRegexp = regexp.MustCompile(`^\d{1,2}:\d{2}(:\d{2})?$`)
+
+var epochRegexp = regexp.MustCompile(`^\d{9,13}$`)
+
+// Epoch timestamps with at least this many digits are in milliseconds
+const epochMillisDigits = 12
+
+// Layouts of absolute timestamps. Timestamps without timezone offset are in the local timezone (see --tz).
+var timeLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02 15:04:05.999999999 MST",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	// Kibana
+	"Jan 2, 2006 @ 15:04:05.999999999",
+	// Sentry
+	"Jan 2, 2006 3:04:05 PM MST",
+	"Jan 2, 2006 3:04:05 PM",
+	"Jan 2, 2006 3:04 PM",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.UnixDate,
+}
+
+// Parses duration given as numbers followed by units (ms, s, m, h, d, w), units can be combined (1h30m, 1d12h)
+func parseDuration(expression string) (time.Duration, error) {
+	remaining := strings.TrimSpace(expression)
+	if remaining == "" {
+		return 0, fmt.Errorf("Duration is empty")
+	}
+	var duration time.Duration
+	for remaining != "" {
+		match := durationRegexp.FindStringSubmatch(remaining)
+		if match == nil {
+			return 0, fmt.Errorf("Invalid duration '%s'. Expected numbers followed by units ms, s, m, h, d or w, e.g. 15m, 1h30m or 2w", expression)
+		}
+		number, _ := strconv.ParseFloat(match[1], 64)
+		duration += time.Duration(number * float64(durationUnits[match[2]]))
+		remaining = remaining[len(match[0]):]
+	}
+	return duration, nil
+}
+
+// Parses time expression relative to now. Supported expressions:
+//   now, now-2h, now+30m          relative to now, any duration accepted by parseDuration
+//   today, yesterday, yesterday 14:00, 14:00
+//   2016-11-10T10:01:23.200, 2016-11-10 10:01:23+01:00, 2016-11-10 and other layouts of timeLayouts
+//   1478772083, 1478772083200     epoch seconds or milliseconds
+// Expressions without timezone are in given location.
+func parseTimeExpression(expression string, now time.Time, location *time.Location) (time.Time, error) {
+	expression = strings.TrimSpace(expression)
+	now = now.In(location)
+
+	if match := relativeTimeRegexp.FindStringSubmatch(strings.ToLower(expression)); match != nil {
+		anchor := now
+		if match[1] != "now" {
+			anchor = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+			if match[1] == "yesterday" {
+				anchor = anchor.AddDate(0, 0, -1)
+			}
+		}
+		if match[4] != "" {
+			return atTimeOfDay(anchor, match[4])
+		}
+		if match[3] != "" {
+			offset, err := parseDuration(match[3])
+			if err != nil {
+				return time.Time{}, err
+			}
+			if match[2] == "-" {
+				offset = -offset
+			}
+			anchor = anchor.Add(offset)
+		}
+		return anchor, nil
+	}
+
+	if timeOfDayRegexp.MatchString(expression) {
+		return atTimeOfDay(now, expression)
+	}
+
+	if epochRegexp.MatchString(expression) {
+		epoch, _ := strconv.ParseInt(expression, 10, 64)
+		if len(expression) >= epochMillisDigits {
+			return time.Unix(0, epoch*int64(time.Millisecond)), nil
+		}
+		return time.Unix(epoch, 0), nil
+	}
+
+	for _, layout := range timeLayouts {
+		if parsed, err := time.ParseInLocation(layout, expression, location); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid time '%s'. Expected timestamp like 2016-11-10T10:01:23.200 (optionally with timezone offset), "+
+		"epoch seconds or milliseconds, or relative time like now-2h, today or yesterday 14:00", expression)
+}
+
+// Returns time of day (15:04 or 15:04:05) on the day of given time
+func atTimeOfDay(day time.Time, timeOfDay string) (time.Time, error) {
+	parts := strings.Split(timeOfDay, ":")
+	values := make([]int, 3)
+	for i, part := range parts {
+		values[i], _ = strconv.Atoi(part)
+	}
+	if values[0] > 23 || values[1] > 59 || values[2] > 59 {
+		return time.Time{}, fmt.Errorf("Invalid time of day '%s'", timeOfDay)
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), values[0], values[1], values[2], 0, day.Location()), nil
+}
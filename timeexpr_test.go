@@ -0,0 +1,174 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseDuration(t *testing.T) {
+	cases := []struct {
+		expression string
+		expected   time.Duration
+	}{
+		{"90s", 90 * time.Second},
+		{"15m", 15 * time.Minute},
+		{"1m30s", 90 * time.Second},
+		{"1ms", time.Millisecond},
+		{"1m1ms", time.Minute + time.Millisecond},
+		{"250ms", 250 * time.Millisecond},
+		{"1h30m", 90 * time.Minute},
+		{"1.5h", 90 * time.Minute},
+		{"1d12h", 36 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{" 2h ", 2 * time.Hour},
+	}
+	for _, c := range cases {
+		duration, err := parseDuration(c.expression)
+		if err != nil {
+			t.Errorf("%s: %s", c.expression, err)
+		} else if duration != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.expression, c.expected, duration)
+		}
+	}
+	for _, expression := range []string{"", "1", "h", "1y", "1mo", "1h 30m", "-1h", "1h-", "m30s"} {
+		if duration, err := parseDuration(expression); err == nil {
+			t.Errorf("%s: expected error, got %s", expression, duration)
+		}
+	}
+}
+
+func TestParseTimeExpression(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 6, 15, 12, 30, 45, 0, time.UTC)
+	cases := []struct {
+		expression string
+		location   *time.Location
+		now        time.Time
+		expected   string //RFC 3339 in UTC
+	}{
+		//relative to now
+		{"now", time.UTC, now, "2024-06-15T12:30:45Z"},
+		{"now-2h", time.UTC, now, "2024-06-15T10:30:45Z"},
+		{"NOW - 90m", time.UTC, now, "2024-06-15T11:00:45Z"},
+		{"now+1d", time.UTC, now, "2024-06-16T12:30:45Z"},
+		{"now-1m30s", time.UTC, now, "2024-06-15T12:29:15Z"},
+		{"now-1ms", time.UTC, now, "2024-06-15T12:30:44.999Z"},
+		{"now-2h", berlin, now, "2024-06-15T10:30:45Z"},
+		//days in the given location
+		{"today", time.UTC, now, "2024-06-15T00:00:00Z"},
+		{"today", berlin, now, "2024-06-14T22:00:00Z"},
+		{"today", berlin, time.Date(2024, 6, 15, 23, 30, 0, 0, time.UTC), "2024-06-15T22:00:00Z"},
+		{"today-2h", time.UTC, now, "2024-06-14T22:00:00Z"},
+		{"yesterday", time.UTC, now, "2024-06-14T00:00:00Z"},
+		{"yesterday 14:00", time.UTC, now, "2024-06-14T14:00:00Z"},
+		{"Yesterday 14:00:30", berlin, now, "2024-06-14T12:00:30Z"},
+		{"08:15", time.UTC, now, "2024-06-15T08:15:00Z"},
+		{"8:15", berlin, now, "2024-06-15T06:15:00Z"},
+		//epoch seconds and milliseconds are not affected by the location
+		{"1718454645", berlin, now, "2024-06-15T12:30:45Z"},
+		{"1718454645123", berlin, now, "2024-06-15T12:30:45.123Z"},
+		{"999999999", time.UTC, now, "2001-09-09T01:46:39Z"},
+		//absolute timestamps, without offset in the given location
+		{"2024-06-15T10:01:23.200", time.UTC, now, "2024-06-15T10:01:23.2Z"},
+		{"2024-06-15T10:01:23.200", berlin, now, "2024-06-15T08:01:23.2Z"},
+		{"2024-06-15T10:01:23Z", berlin, now, "2024-06-15T10:01:23Z"},
+		{"2024-06-15T10:01:23.5+0100", berlin, now, "2024-06-15T09:01:23.5Z"},
+		{"2024-06-15 10:01:23+01:00", berlin, now, "2024-06-15T09:01:23Z"},
+		{"2024-06-15 10:01:23 -0700", berlin, now, "2024-06-15T17:01:23Z"},
+		{"2024-06-15 10:01:23", berlin, now, "2024-06-15T08:01:23Z"},
+		{"2024-06-15T10:01", berlin, now, "2024-06-15T08:01:00Z"},
+		{"2024-06-15 10:01", time.UTC, now, "2024-06-15T10:01:00Z"},
+		{"2024-06-15", berlin, now, "2024-06-14T22:00:00Z"},
+		{"Jun 15, 2024 @ 10:01:23.200", berlin, now, "2024-06-15T08:01:23.2Z"},
+		{"Jun 15, 2024 @ 10:01:23", time.UTC, now, "2024-06-15T10:01:23Z"},
+		{"Jun 15, 2024 3:04:05 PM", time.UTC, now, "2024-06-15T15:04:05Z"},
+		{"Jun 15, 2024 3:04 PM", berlin, now, "2024-06-15T13:04:00Z"},
+		{"Sat, 15 Jun 2024 10:01:23 +0200", time.UTC, now, "2024-06-15T08:01:23Z"},
+		//around daylight saving time changes, durations are exact
+		{"now-24h", berlin, time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC), "2024-03-30T12:00:00Z"},
+		{"yesterday", berlin, time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC), "2024-03-30T23:00:00Z"},
+		{"yesterday 04:00", berlin, time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC), "2024-03-31T02:00:00Z"},
+		{"2024-10-27 01:30", berlin, now, "2024-10-26T23:30:00Z"},
+		{"2024-10-27 03:30", berlin, now, "2024-10-27T02:30:00Z"},
+		{"2024-10-27T02:30+01:00", berlin, now, "2024-10-27T01:30:00Z"},
+	}
+	for _, c := range cases {
+		parsed, err := parseTimeExpression(c.expression, c.now, c.location)
+		if err != nil {
+			t.Errorf("%s (%s): %s", c.expression, c.location, err)
+			continue
+		}
+		if actual := parsed.UTC().Format(time.RFC3339Nano); actual != c.expected {
+			t.Errorf("%s (%s): expected %s, got %s", c.expression, c.location, c.expected, actual)
+		}
+	}
+	for _, expression := range []string{"", "tomorrow", "now-2x", "now-", "yesterday 24:00", "12:60", "2024-13-01",
+		"12345", "15.06.2024"} {
+		if parsed, err := parseTimeExpression(expression, now, time.UTC); err == nil {
+			t.Errorf("%s: expected error, got %s", expression, parsed)
+		}
+	}
+}
+
+func TestResolveTimeExpressions(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(tz *time.Location) { localTz = tz }(localTz)
+	now := time.Date(2024, 6, 15, 12, 30, 45, 0, time.UTC)
+	//clocks are turned back from 03:00 CEST to 02:00 CET, local times between 02:00 and 03:00 happen twice
+	fallBack := time.Date(2024, 10, 27, 1, 45, 0, 0, time.UTC)
+	cases := []struct {
+		after, before  string
+		location       *time.Location
+		now            time.Time
+		expectedAfter  string
+		expectedBefore string
+	}{
+		{"now-2h", "now", time.UTC, now, "2024-06-15T10:30:45Z", "2024-06-15T12:30:45Z"},
+		{"yesterday 14:00", "", berlin, now, "2024-06-14T12:00:00Z", ""},
+		{"", "2024-06-15T10:01:23.123456", berlin, now, "", "2024-06-15T08:01:23.123456Z"},
+		{"1718454645123", "", berlin, now, "2024-06-15T12:30:45.123Z", ""},
+		{"now-1m30s", "now-1ms", berlin, now, "2024-06-15T12:29:15Z", "2024-06-15T12:30:44.999Z"},
+		{"2024-06-15T10:01:23Z", "", berlin, now, "2024-06-15T10:01:23Z", ""},
+		//02:35 CET, the second 02:35 of the day
+		{"now-10m", "now", berlin, fallBack, "2024-10-27T01:35:00Z", "2024-10-27T01:45:00Z"},
+		//02:15 CEST, the first 02:15 of the day
+		{"now-90m", "", berlin, fallBack, "2024-10-27T00:15:00Z", ""},
+	}
+	for _, c := range cases {
+		localTz = c.location
+		q := &QueryDefinition{AfterDateTime: c.after, BeforeDateTime: c.before}
+		if err := q.ResolveTimeExpressions(c.now); err != nil {
+			t.Errorf("%s - %s: %s", c.after, c.before, err)
+			continue
+		}
+		if c.after != "" && q.AfterDateTimeInUTC() != c.expectedAfter {
+			t.Errorf("%s (%s): expected %s, got %s (resolved %s)", c.after, c.location, c.expectedAfter, q.AfterDateTimeInUTC(), q.AfterDateTime)
+		}
+		if c.before != "" && q.BeforeDateTimeInUTC() != c.expectedBefore {
+			t.Errorf("%s (%s): expected %s, got %s (resolved %s)", c.before, c.location, c.expectedBefore, q.BeforeDateTimeInUTC(), q.BeforeDateTime)
+		}
+	}
+
+	localTz = time.UTC
+	for _, q := range []QueryDefinition{
+		{Duration: "1m30x"},
+		{TraceWindow: "5"},
+		{AfterDateTime: "tomorrow"},
+		{AfterDateTime: "now", BeforeDateTime: "now-1y"},
+	} {
+		if err := q.ResolveTimeExpressions(now); err == nil {
+			t.Errorf("%#v: expected error", q)
+		}
+	}
+	valid := QueryDefinition{Duration: "1h30m", TraceWindow: TraceWindowAll}
+	if err := valid.ResolveTimeExpressions(now); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
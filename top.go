@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Maximum number of fields in --top, the second field breaks down each value of the first one
+const topMaxFields = 2
+
+// Row of the top terms table. Values holds one cell per field, only the cell of the row's level is filled.
+type topRow struct {
+	values []string
+	count  int64
+}
+
+// Prints the most frequent values of the fields given using --top among entries matching the query (keywords,
+// sources, field and time filters) with counts and percentages of all matching entries. If two fields are given,
+// each value of the first field is broken down by values of the second one.
+func (t *Tail) TopTerms() {
+	fields, err := t.queryDefinition.TopFieldList()
+	if err != nil {
+		Error.Fatalln(err)
+	}
+	result, err := t.backend.Search(&SearchRequest{
+		Indices:      t.indices,
+		Query:        t.buildSearchQuery(),
+		Size:         0,
+		Aggregations: map[string]Aggregation{"top": t.topTermsAggregation(fields)},
+	})
+	if err != nil {
+		Error.Fatalln("Error in executing search query.", err)
+	}
+	if result.TotalHits == 0 {
+		fmt.Fprintln(InfoOutput, paintInfoline("No entries found"))
+		return
+	}
+
+	rows := topRows(result.Aggregations, len(fields), 0)
+	printTopTable(fields, rows, result.TotalHits)
+}
+
+// Builds terms aggregation of the first field with terms aggregations of the following fields nested in it
+func (t *Tail) topTermsAggregation(fields []string) TermsAggregation {
+	aggregation := TermsAggregation{Field: fields[0], Size: t.queryDefinition.TopSize}
+	if len(fields) > 1 {
+		aggregation.SubAggregations = map[string]Aggregation{"top": t.topTermsAggregation(fields[1:])}
+	}
+	return aggregation
+}
+
+// Flattens nested buckets into table rows, each bucket is followed by rows of its breakdown. Entries not included
+// in the buckets are summed up in "(other)" row.
+func topRows(aggregations AggregationResults, levels int, level int) []*topRow {
+	buckets, _ := aggregations.Buckets("top")
+	rows := []*topRow{}
+	for _, bucket := range buckets {
+		rows = append(rows, newTopRow(levels, level, bucket.KeyString(), bucket.DocCount))
+		if level+1 < levels {
+			rows = append(rows, topRows(bucket.Aggregations, levels, level+1)...)
+		}
+	}
+	if other := aggregations.OtherDocCount("top"); other > 0 {
+		rows = append(rows, newTopRow(levels, level, "(other)", other))
+	}
+	return rows
+}
+
+func newTopRow(levels int, level int, value string, count int64) *topRow {
+	values := make([]string, levels)
+	values[level] = value
+	return &topRow{values: values, count: count}
+}
+
+func printTopTable(fields []string, rows []*topRow, total int64) {
+	widths := make([]int, len(fields))
+	for i, field := range fields {
+		widths[i] = len(field)
+	}
+	for _, row := range rows {
+		for i, value := range row.values {
+			if len(value) > widths[i] {
+				widths[i] = len(value)
+			}
+		}
+	}
+	countWidth := len(fmt.Sprintf("%d", total))
+	if countWidth < len("count") {
+		countWidth = len("count")
+	}
+
+	header := make([]string, len(fields))
+	for i, field := range fields {
+		header[i] = rightPad2Len(field, " ", widths[i])
+	}
+	fmt.Println(paintInfoline(fmt.Sprintf("%s  %*s  %7s", strings.Join(header, "  "), countWidth, "count", "%")))
+	for _, row := range rows {
+		cells := make([]string, len(row.values))
+		for i, value := range row.values {
+			cells[i] = rightPad2Len(value, " ", widths[i])
+		}
+		fmt.Printf("%s  %*d  %6.2f%%\n", strings.Join(cells, "  "), countWidth, row.count,
+			float64(row.count)*100/float64(total))
+	}
+	fmt.Println(paintInfoline(fmt.Sprintf("Total %d matching entries", total)))
+}
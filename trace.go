@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trace window searching all indices matching the index pattern
+const TraceWindowAll = "all"
+
+// Maximum number of entries fetched for a traced request
+const traceMaxEntries = 10000
+
+const tracePageSize = 1000
+
+// Fields that may hold the host which logged the entry, the first one present is used
+var traceHostFields = []string{"host", "source_host", "hostname", "beat.hostname"}
+
+// Fields summarized at the end of the trace if present in the entries
+const (
+	traceStatusField   = "status"
+	traceDurationField = "duration"
+)
+
+type traceEntry struct {
+	timestamp time.Time
+	entry     map[string]interface{}
+}
+
+// Group of trace entries logged by the same source on the same host
+type traceGroup struct {
+	name    string
+	entries int
+	first   time.Time
+	last    time.Time
+}
+
+// Reconstructs the timeline of the request. All the entries of the request are fetched, printed in order with
+// elapsed time since the first entry and since the previous one, followed by entries grouped by source and host and
+// summary of status and duration fields.
+func (t *Tail) TraceRequest() {
+	entries, err := t.fetchTraceEntries()
+	if err != nil {
+		Error.Fatalln("Error in executing search query.", err)
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(InfoOutput, paintInfoline("No entries found for request id "+t.queryDefinition.RequestId))
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].timestamp.Before(entries[j].timestamp)
+	})
+	first := entries[0].timestamp
+	last := entries[len(entries)-1].timestamp
+
+	groups := t.traceGroups(entries)
+	fmt.Println(paintInfoline(fmt.Sprintf("Request %s: %d entries from %d sources, took %s",
+		t.queryDefinition.RequestId, len(entries), len(groups), last.Sub(first))))
+
+	fmt.Println(paintInfoline("Timeline:"))
+	previous := first
+	for _, e := range entries {
+		fmt.Printf("%12s %14s  %s\n", "+"+e.timestamp.Sub(first).String(), "(+"+e.timestamp.Sub(previous).String()+")",
+			t.formatEntry(e.entry, nil))
+		previous = e.timestamp
+	}
+
+	fmt.Println(paintInfoline("By source:"))
+	for _, group := range groups {
+		fmt.Printf("  %-40s %5d entries  from +%s to +%s\n", paintSource(group.name), group.entries,
+			group.first.Sub(first), group.last.Sub(first))
+	}
+
+	if summary := traceSummary(entries); len(summary) > 0 {
+		fmt.Println(paintInfoline("Summary:"))
+		for _, line := range summary {
+			fmt.Println("  " + line)
+		}
+	}
+}
+
+// Fetches all the entries of the request in ascending order
+func (t *Tail) fetchTraceEntries() ([]*traceEntry, error) {
+	request := t.initialSearchRequest(tracePageSize)
+	request.Sort = []SortField{{Field: t.queryDefinition.TimestampField, Ascending: true}}
+
+	entries := []*traceEntry{}
+	for request.From < traceMaxEntries {
+		result, err := t.backend.Search(request)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range result.Hits {
+			var entry map[string]interface{}
+			if err := json.Unmarshal(hit.Source, &entry); err != nil {
+				Error.Fatalln("Failed parsing ElasticSearch response.", err)
+			}
+			timestamp, _ := entry[t.queryDefinition.TimestampField].(string)
+			parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+			if err != nil {
+				Trace.Printf("Failed parsing timestamp %s of traced entry", timestamp)
+			}
+			entries = append(entries, &traceEntry{timestamp: parsed, entry: entry})
+		}
+		if len(result.Hits) < tracePageSize {
+			return entries, nil
+		}
+		request.From += tracePageSize
+	}
+	fmt.Fprintln(InfoOutput, paintInfoline(fmt.Sprintf("Only the first %d entries of the request are shown", traceMaxEntries)))
+	return entries, nil
+}
+
+// Groups entries by source and host, groups are ordered by their first entry
+func (t *Tail) traceGroups(entries []*traceEntry) []*traceGroup {
+	groups := []*traceGroup{}
+	byName := make(map[string]*traceGroup)
+	for _, e := range entries {
+		name := fieldValue(e.entry, "source")
+		if host := traceHost(e.entry); host != "" {
+			name += "@" + host
+		}
+		group, ok := byName[name]
+		if !ok {
+			group = &traceGroup{name: name, first: e.timestamp}
+			byName[name] = group
+			groups = append(groups, group)
+		}
+		group.entries++
+		group.last = e.timestamp
+	}
+	return groups
+}
+
+func traceHost(entry map[string]interface{}) string {
+	for _, field := range traceHostFields {
+		if host := fieldValue(entry, field); host != "" {
+			return host
+		}
+	}
+	return ""
+}
+
+// Summarizes status codes and durations of the entries, if present
+func traceSummary(entries []*traceEntry) []string {
+	summary := []string{}
+
+	statuses := make(map[string]int)
+	statusOrder := []string{}
+	durations := []float64{}
+	for _, e := range entries {
+		if status := fieldValue(e.entry, traceStatusField); status != "" {
+			if statuses[status] == 0 {
+				statusOrder = append(statusOrder, status)
+			}
+			statuses[status]++
+		}
+		if duration, err := strconv.ParseFloat(fieldValue(e.entry, traceDurationField), 64); err == nil {
+			durations = append(durations, duration)
+		}
+	}
+
+	if len(statusOrder) > 0 {
+		counts := make([]string, 0, len(statusOrder))
+		for _, status := range statusOrder {
+			counts = append(counts, fmt.Sprintf("%s (%d)", status, statuses[status]))
+		}
+		summary = append(summary, traceStatusField+": "+strings.Join(counts, ", "))
+	}
+	if len(durations) > 0 {
+		min, max, sum := durations[0], durations[0], 0.0
+		for _, d := range durations {
+			if d < min {
+				min = d
+			}
+			if d > max {
+				max = d
+			}
+			sum += d
+		}
+		summary = append(summary, fmt.Sprintf("%s: min %g, avg %.2f, max %g, total %g (%d entries)",
+			traceDurationField, min, sum/float64(len(durations)), max, sum, len(durations)))
+	}
+	return summary
+}
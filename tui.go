@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Maximum number of entries kept in the browser, the oldest ones are dropped when following
+const browserMaxEntries = 10000
+
+// How often new entries are fetched when following
+const browserFollowInterval = 1 * time.Second
+
+const browserHelp = "j/k move  PgUp/PgDn page  g/G first/last  Enter detail  / query  r request  s source  f follow  q quit"
+
+// ANSI escape sequences used to draw the screen
+const (
+	ansiAlternateScreen = "\x1b[?1049h\x1b[?25l"
+	ansiMainScreen      = "\x1b[?25h\x1b[?1049l"
+	ansiClearLine       = "\x1b[K"
+	ansiReverse         = "\x1b[7m"
+	ansiReset           = "\x1b[0m"
+)
+
+type browserEntry struct {
+	hit       *SearchHit
+	entry     map[string]interface{}
+	timestamp string
+	line      string //entry formatted without colors
+}
+
+//
+// Full screen terminal UI for browsing the entries. Entries are kept in ascending order, older entries are fetched
+// when scrolling above the first one and newer entries when scrolling below the last one or when following.
+//
+type browser struct {
+	tail     *Tail
+	pageSize int
+	entries  []*browserEntry
+	selected int
+	top      int //index of the first entry on the screen
+	detail   bool
+	follow   bool
+	help     bool
+	query    *lineEditor //query being edited in the query bar, nil when not editing
+	status   string
+	// Filters applied by jumping to request id or source of an entry, with the original values restored on return
+	jumpedRequestId, savedRequestId string
+	jumpedSource, savedSource       string
+	screen                          *bufio.Writer
+	width, height                   int
+}
+
+// Browses entries matching the query in a full screen terminal UI
+func (t *Tail) Browse(entriesPerBatch int) {
+	if !isInteractive() || !terminal.IsTerminal(int(os.Stdout.Fd())) {
+		Error.Fatalln("Terminal UI needs an interactive terminal")
+	}
+	state, err := terminal.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		Error.Fatalln("Failed to switch terminal to raw mode.", err)
+	}
+	b := &browser{tail: t, pageSize: entriesPerBatch, follow: t.tailMode, screen: bufio.NewWriter(os.Stdout)}
+	b.screen.WriteString(ansiAlternateScreen)
+	defer func() {
+		b.screen.WriteString(ansiMainScreen)
+		b.screen.Flush()
+		terminal.Restore(int(os.Stdin.Fd()), state)
+	}()
+
+	keys := make(chan key)
+	go func() {
+		for {
+			k, err := readKey()
+			if err != nil {
+				k = keyInterrupt
+			}
+			keys <- k
+		}
+	}()
+	ticker := time.NewTicker(browserFollowInterval)
+	defer ticker.Stop()
+
+	b.reload()
+	for {
+		b.render()
+		select {
+		case k := <-keys:
+			if !b.handleKey(k) {
+				return
+			}
+		case <-ticker.C:
+			if b.follow {
+				b.loadNewer()
+			}
+		}
+	}
+}
+
+// Handles key press. Returns false when the user quits.
+func (b *browser) handleKey(k key) bool {
+	if b.query != nil {
+		if finished, cancelled := b.query.edit(k); finished {
+			if !cancelled {
+				b.applyQuery(b.query.String())
+			}
+			b.query = nil
+		}
+		return true
+	}
+	b.status = ""
+	switch k {
+	case 'q', keyInterrupt:
+		return false
+	case 'j', keyDown:
+		b.move(1)
+	case 'k', keyUp:
+		b.move(-1)
+	case ' ', keyPageDown:
+		b.move(b.listHeight())
+	case 'b', keyPageUp:
+		b.move(-b.listHeight())
+	case 'g', keyHome:
+		b.move(-len(b.entries))
+	case 'G', keyEnd:
+		b.move(len(b.entries))
+	case keyEnter:
+		b.detail = !b.detail
+	case keyEscape:
+		b.detail = false
+		b.help = false
+	case '/':
+		b.query = newLineEditor(strings.Join(b.tail.queryDefinition.Terms, " "))
+	case 'r':
+		b.jumpToRequestId()
+	case 's':
+		b.jumpToSource()
+	case 'f':
+		b.follow = !b.follow
+		if b.follow {
+			b.loadNewer()
+			b.move(len(b.entries))
+		}
+	case '?':
+		b.help = !b.help
+	}
+	return true
+}
+
+// Moves the selection, fetching older or newer entries when moving past the loaded ones
+func (b *browser) move(delta int) {
+	target := b.selected + delta
+	if target < 0 && delta < 0 && b.selected == 0 {
+		target += b.loadOlder()
+	} else if target >= len(b.entries) && delta > 0 && b.selected == len(b.entries)-1 {
+		b.loadNewer()
+	}
+	if target >= len(b.entries) {
+		target = len(b.entries) - 1
+	}
+	if target < 0 {
+		target = 0
+	}
+	b.selected = target
+}
+
+// Fetches the most recent entries matching the query and selects the last one
+func (b *browser) reload() {
+	hits, err := b.tail.fetchPageBefore("", nil, b.pageSize)
+	if err != nil {
+		b.status = fmt.Sprintf("Search failed: %s", err)
+		return
+	}
+	b.entries = nil
+	b.tail.cursor = newTailCursor()
+	for i := len(hits) - 1; i >= 0; i-- {
+		if e := b.newEntry(hits[i]); e != nil && b.tail.cursor.advance(e.timestamp, e.hit.Uid()) {
+			b.entries = append(b.entries, e)
+		}
+	}
+	b.selected = len(b.entries) - 1
+	b.top = 0
+	if len(b.entries) == 0 {
+		b.status = "No entries match the query"
+	}
+}
+
+// Prepends entries preceding the first loaded entry. Returns the number of prepended entries.
+func (b *browser) loadOlder() int {
+	if len(b.entries) == 0 {
+		return 0
+	}
+	boundary := b.entries[0].timestamp
+	exclude := map[string]bool{}
+	for _, e := range b.entries {
+		if e.timestamp != boundary {
+			break
+		}
+		exclude[e.hit.Uid()] = true
+	}
+	hits, err := b.tail.fetchPageBefore(boundary, exclude, b.pageSize)
+	if err != nil {
+		b.status = fmt.Sprintf("Search failed: %s", err)
+		return 0
+	}
+	if len(hits) == 0 {
+		b.status = "No older entries"
+		return 0
+	}
+	older := make([]*browserEntry, 0, len(hits))
+	for i := len(hits) - 1; i >= 0; i-- {
+		if e := b.newEntry(hits[i]); e != nil {
+			older = append(older, e)
+		}
+	}
+	b.entries = append(older, b.entries...)
+	b.selected += len(older)
+	b.top += len(older)
+	return len(older)
+}
+
+// Appends entries following the last loaded entry. Selection follows the new entries if the last entry was selected.
+func (b *browser) loadNewer() {
+	if b.tail.cursor.isEmpty() {
+		//nothing matched so far, following would start from the oldest entries
+		b.reload()
+		return
+	}
+	atEnd := b.selected >= len(b.entries)-1
+	//pages are fetched until one of them has new entries, as a page may consist of entries that were already seen
+	_, err := b.tail.followCursor(b.pageSize, 1, func(result *SearchResponse) int {
+		loaded := 0
+		for _, hit := range result.Hits {
+			if e := b.newEntry(hit); e != nil && b.tail.cursor.advance(e.timestamp, hit.Uid()) {
+				b.entries = append(b.entries, e)
+				loaded++
+			}
+		}
+		return loaded
+	})
+	if err != nil {
+		b.status = fmt.Sprintf("Search failed: %s", err)
+		return
+	}
+	if dropped := len(b.entries) - browserMaxEntries; dropped > 0 {
+		b.entries = b.entries[dropped:]
+		b.selected -= dropped
+		b.top -= dropped
+	}
+	if atEnd {
+		b.selected = len(b.entries) - 1
+	}
+}
+
+func (b *browser) newEntry(hit *SearchHit) *browserEntry {
+	var entry map[string]interface{}
+	if err := json.Unmarshal(hit.Source, &entry); err != nil {
+		b.status = fmt.Sprintf("Failed parsing entry %s: %s", hit.Id, err)
+		return nil
+	}
+	timestamp, _ := entry[b.tail.queryDefinition.TimestampField].(string)
+	noColor := color.NoColor
+	color.NoColor = true
+	line := b.tail.formatEntry(entry, nil)
+	color.NoColor = noColor
+	line = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' || r == '\t' {
+			return ' '
+		}
+		return r
+	}, line)
+	return &browserEntry{hit: hit, entry: entry, timestamp: timestamp, line: line}
+}
+
+// Replaces keywords of the query and searches again
+func (b *browser) applyQuery(query string) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		b.tail.queryDefinition.Terms = []string{}
+	} else {
+		b.tail.queryDefinition.Terms = []string{query}
+	}
+	b.tail.highlighter = newHighlighter(query, b.tail.watcher)
+	b.reload()
+}
+
+// Shows all entries of the request of the selected entry, or returns to the original query if already shown
+func (b *browser) jumpToRequestId() {
+	q := b.tail.queryDefinition
+	if b.jumpedRequestId != "" {
+		q.RequestId = b.savedRequestId
+		b.jumpedRequestId = ""
+	} else if e := b.selectedEntry(); e != nil {
+		requestId := fieldValue(e.entry, "x_request_id")
+		if requestId == "" {
+			b.status = "Entry has no request id"
+			return
+		}
+		b.savedRequestId = q.RequestId
+		b.jumpedRequestId = requestId
+		q.RequestId = requestId
+	}
+	b.reload()
+}
+
+// Shows entries of the source of the selected entry, or returns to the original query if already shown
+func (b *browser) jumpToSource() {
+	q := b.tail.queryDefinition
+	if b.jumpedSource != "" {
+		q.Source = b.savedSource
+		b.jumpedSource = ""
+	} else if e := b.selectedEntry(); e != nil {
+		source := fieldValue(e.entry, "source")
+		if source == "" {
+			b.status = "Entry has no source"
+			return
+		}
+		b.savedSource = q.Source
+		b.jumpedSource = source
+		q.Source = source
+	}
+	b.reload()
+}
+
+func (b *browser) selectedEntry() *browserEntry {
+	if b.selected < 0 || b.selected >= len(b.entries) {
+		return nil
+	}
+	return b.entries[b.selected]
+}
+
+// Number of screen lines available for the list of entries
+func (b *browser) listHeight() int {
+	height := b.height - 2 //query bar and status bar
+	if b.detail {
+		height = height / 2
+	}
+	if height < 1 {
+		height = 1
+	}
+	return height
+}
+
+// Draws the query bar, the list of entries, the detail pane and the status bar
+func (b *browser) render() {
+	b.width, b.height = defaultTerminalWidth, 24
+	if width, height, err := terminal.GetSize(int(os.Stdout.Fd())); err == nil {
+		b.width, b.height = width, height
+	}
+	listHeight := b.listHeight()
+	if b.selected < b.top {
+		b.top = b.selected
+	}
+	if b.selected >= b.top+listHeight {
+		b.top = b.selected - listHeight + 1
+	}
+	if b.top < 0 {
+		b.top = 0
+	}
+
+	row := 1
+	b.drawLine(row, ansiReverse+b.fit(b.queryBar())+ansiReset)
+	for i := 0; i < listHeight; i++ {
+		row++
+		index := b.top + i
+		switch {
+		case index >= len(b.entries):
+			b.drawLine(row, "")
+		case index == b.selected:
+			b.drawLine(row, ansiReverse+b.fit(b.entries[index].line)+ansiReset)
+		default:
+			b.drawLine(row, b.tail.highlighter.highlight(b.fit(b.entries[index].line), nil))
+		}
+	}
+	if b.detail {
+		detail := b.detailLines()
+		row++
+		b.drawLine(row, paintInfoline(b.fit(strings.Repeat("-", b.width))))
+		for row < b.height-1 {
+			row++
+			line := ""
+			if len(detail) > 0 {
+				line, detail = detail[0], detail[1:]
+			}
+			b.drawLine(row, b.fit(line))
+		}
+	}
+	b.drawLine(b.height, ansiReverse+b.fit(b.statusBar())+ansiReset)
+	b.screen.Flush()
+}
+
+func (b *browser) drawLine(row int, content string) {
+	fmt.Fprintf(b.screen, "\x1b[%d;1H%s%s", row, content, ansiClearLine)
+}
+
+// Cuts the line to the screen width, or pads it so that reverse video spans the whole line
+func (b *browser) fit(line string) string {
+	runes := []rune(line)
+	if len(runes) > b.width {
+		return string(runes[:b.width])
+	}
+	return line + strings.Repeat(" ", b.width-len(runes))
+}
+
+func (b *browser) queryBar() string {
+	if b.query != nil {
+		return " Query: " + b.query.String() + "_"
+	}
+	q := b.tail.queryDefinition
+	bar := " Query: " + strings.Join(q.Terms, " ")
+	if q.Source != "" {
+		bar += "  Source: " + q.Source
+	}
+	if q.RequestId != "" {
+		bar += "  Request: " + q.RequestId
+	}
+	if len(q.Filters) > 0 {
+		bar += "  Filters: " + strings.Join(q.Filters, " ")
+	}
+	return bar
+}
+
+func (b *browser) statusBar() string {
+	if b.help {
+		return " " + browserHelp
+	}
+	follow := "off"
+	if b.follow {
+		follow = "on"
+	}
+	bar := fmt.Sprintf(" %d/%d  follow %s  ? help", b.selected+1, len(b.entries), follow)
+	if b.status != "" {
+		bar += "  | " + b.status
+	}
+	return bar
+}
+
+// Full JSON of the selected entry
+func (b *browser) detailLines() []string {
+	e := b.selectedEntry()
+	if e == nil {
+		return nil
+	}
+	content, err := json.MarshalIndent(e.entry, "", "  ")
+	if err != nil {
+		return []string{err.Error()}
+	}
+	lines := []string{fmt.Sprintf("_index: %s  _id: %s", e.hit.Index, e.hit.Id)}
+	return append(lines, strings.Split(string(content), "\n")...)
+}
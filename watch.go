@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// Field searched by literal and regexp watch rules
+const watchField = "message"
+
+// Exit code of the process when it exits after the number of matches given using --watch-exit
+const watchExitCode = 3
+
+// Actions taken when an entry matches one of the watch rules
+type WatchActions struct {
+	Bell      bool
+	Command   string
+	File      string
+	ExitAfter int
+}
+
+func (a *WatchActions) isSet() bool {
+	return a.Bell || a.Command != "" || a.File != "" || a.ExitAfter > 0
+}
+
+//
+// Condition of a watch rule - phrase or regexp searched in the message, or field filter
+//
+type watchRule interface {
+	matches(entry map[string]interface{}) bool
+}
+
+type literalWatchRule struct {
+	phrase string
+}
+
+func (r *literalWatchRule) matches(entry map[string]interface{}) bool {
+	return strings.Contains(fieldValue(entry, watchField), r.phrase)
+}
+
+type regexpWatchRule struct {
+	regexp *regexp.Regexp
+}
+
+func (r *regexpWatchRule) matches(entry map[string]interface{}) bool {
+	return r.regexp.MatchString(fieldValue(entry, watchField))
+}
+
+type fieldWatchRule struct {
+	filter Filter
+}
+
+func (r *fieldWatchRule) matches(entry map[string]interface{}) bool {
+	return filterMatches(r.filter, entry)
+}
+
+// Parses watch rule given using -w option. Rules enclosed in slashes are regular expressions, e.g. /OutOfMemory|StackOverflow/
+func parseWatchRule(watch string) (watchRule, error) {
+	if len(watch) > 2 && strings.HasPrefix(watch, "/") && strings.HasSuffix(watch, "/") {
+		re, err := regexp.Compile(watch[1 : len(watch)-1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid watch regexp %s: %s", watch, err)
+		}
+		return &regexpWatchRule{regexp: re}, nil
+	}
+	return &literalWatchRule{phrase: watch}, nil
+}
+
+//
+// Checks processed entries against the watch rules, highlights matches and takes the watch actions
+//
+type watcher struct {
+	rules   []watchRule
+	actions WatchActions
+	file    *os.File
+	matches int
+	// Actions are not taken until armed, so that old entries fetched initially in tail mode don't trigger them
+	armed bool
+}
+
+// Creates watcher for the rules given using -w and --watch-field options. Returns nil if there are no rules.
+func newWatcher(watch string, fieldRules []string, actions WatchActions) (*watcher, error) {
+	w := &watcher{actions: actions, armed: true}
+	if watch != "" {
+		rule, err := parseWatchRule(watch)
+		if err != nil {
+			return nil, err
+		}
+		w.rules = append(w.rules, rule)
+	}
+	for _, expression := range fieldRules {
+		filter, err := ParseFieldFilter(expression)
+		if err != nil {
+			return nil, err
+		}
+		w.rules = append(w.rules, &fieldWatchRule{filter: filter})
+	}
+	if len(w.rules) == 0 {
+		if actions.isSet() {
+			return nil, fmt.Errorf("Watch actions need a watch rule given using -w or --watch-field option")
+		}
+		return nil, nil
+	}
+	if actions.File != "" {
+		file, err := os.OpenFile(actions.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open watch file %s: %s", actions.File, err)
+		}
+		w.file = file
+	}
+	return w, nil
+}
+
+// Takes the watch actions if the entry matches any of the rules
+func (w *watcher) process(hit *SearchHit, entry map[string]interface{}) {
+	if !w.armed || !w.matchesAny(entry) {
+		return
+	}
+	w.matches++
+	if w.actions.Bell {
+		fmt.Fprint(os.Stderr, "\a")
+	}
+	if w.actions.Command != "" || w.file != nil {
+		line := entryJSON(hit, entry)
+		if w.actions.Command != "" {
+			w.runCommand(line)
+		}
+		if w.file != nil {
+			if _, err := w.file.Write(line); err != nil {
+				Error.Println("Failed to write matching entry to watch file.", err)
+			}
+		}
+	}
+	if w.actions.ExitAfter > 0 && w.matches >= w.actions.ExitAfter {
+		fmt.Fprintln(InfoOutput, paintInfoline(fmt.Sprintf("Exiting after %d entries matching watch rules", w.matches)))
+		if w.file != nil {
+			w.file.Close()
+		}
+		os.Exit(watchExitCode)
+	}
+}
+
+func (w *watcher) matchesAny(entry map[string]interface{}) bool {
+	for _, rule := range w.rules {
+		if rule.matches(entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// Runs the command using shell with the entry as JSON on standard input. Output of the command goes to stderr, so
+// that it does not mix with the entries.
+func (w *watcher) runCommand(line []byte) {
+	command := exec.Command("sh", "-c", w.actions.Command)
+	command.Stdin = bytes.NewReader(line)
+	command.Stdout = os.Stderr
+	command.Stderr = os.Stderr
+	if err := command.Run(); err != nil {
+		Error.Printf("Watch command %s failed: %s\n", w.actions.Command, err)
+	}
+}
+
+// Returns regexps matching phrases and regexps of the watch rules, used for highlighting
+func (w *watcher) highlightRegexps() []*regexp.Regexp {
+	regexps := []*regexp.Regexp{}
+	for _, rule := range w.rules {
+		switch r := rule.(type) {
+		case *literalWatchRule:
+			regexps = append(regexps, regexp.MustCompile(regexp.QuoteMeta(r.phrase)))
+		case *regexpWatchRule:
+			regexps = append(regexps, r.regexp)
+		}
+	}
+	return regexps
+}
+
+// Returns _source of the entry as a single line of JSON
+func entryJSON(hit *SearchHit, entry map[string]interface{}) []byte {
+	var line bytes.Buffer
+	if err := json.Compact(&line, hit.Source); err != nil {
+		encoded, _ := json.Marshal(entry)
+		line.Reset()
+		line.Write(encoded)
+	}
+	line.WriteByte('\n')
+	return line.Bytes()
+}